@@ -1,16 +1,25 @@
 package web
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-rest-shop-backend/pkg/database"
 	"github.com/golang-rest-shop-backend/pkg/handler"
+	"github.com/golang-rest-shop-backend/pkg/service"
+	"github.com/golang-rest-shop-backend/pkg/structs"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 	"log"
+	"os"
+	"strconv"
+	"time"
 
 	_ "github.com/golang-rest-shop-backend/pkg/swagger"
 )
 
+// reservationExpiryInterval is how often stock of expired reservations is released.
+const reservationExpiryInterval = time.Minute
+
 // @title           Golang Rest Shop Backend
 // @version         1.0
 // @description     This is a sample rest backend for an online shop.
@@ -22,31 +31,156 @@ import (
 
 // @host      localhost:8080
 
-func init() {
-	err := database.InitMySqlConnection()
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Bearer followed by the token from /login
+
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key issued at /admin/apikey
+
+func main() {
+	repository, err := database.InitConnection()
 	if err != nil {
 		log.Fatal(err)
 	}
-}
 
-func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrate(repository, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET has to be set to sign access tokens")
+	}
+
+	var notifier service.Notifier = service.LogNotifier{}
+	if path := os.Getenv("NOTIFICATIONS_FILE"); path != "" {
+		notifier = &service.FileNotifier{Path: path}
+	}
+
+	s := service.NewService(repository.Repositories(), repository, notifier)
+	if email := os.Getenv("ADMIN_EMAIL"); email != "" {
+		if err = s.EnsureAdmin(email, os.Getenv("ADMIN_PASSWORD")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The expiry runs as long as the server does, so it is never stopped.
+	go s.ExpireReservationsEvery(reservationExpiryInterval, nil)
+
+	h := handler.NewHandler(s, []byte(secret))
+
 	r := gin.Default()
-	r.GET("/product", handler.GetAllProductHandler)
-	r.GET("/order", handler.GetAllOrdersHandler)
-	r.GET("/product/:productId", handler.GetProductHandler)
-	r.GET("/order/:orderId", handler.GetOrderHandler)
+	r.GET("/product", h.GetAllProductHandler)
+	r.GET("/product/:productId", h.GetProductHandler)
+	r.POST("/login", h.LoginHandler)
+	r.POST("/register", h.RegisterHandler)
+	r.POST("/password/reset", h.RequestPasswordResetHandler)
+	r.POST("/password/reset/confirm", h.ResetPasswordHandler)
 
-	r.POST("/order", handler.AddOrderHandler)
-	r.POST("/product", handler.AddProductHandler)
+	authorized := r.Group("/", h.Authenticate)
 
-	r.PUT("/order/:orderId", handler.UpdateOrderHandler)
-	r.PUT("/product/:productId", handler.UpdateProductHandler)
+	users := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.RoleCustomer, structs.ScopeAdmin))
+	users.GET("/customer/:customerId", h.GetCustomerHandler)
+	users.GET("/customer/:customerId/orders", h.GetCustomerOrdersHandler)
+	users.PUT("/customer/:customerId", h.UpdateCustomerHandler)
+	users.PUT("/account/password", h.ChangePasswordHandler)
 
-	r.DELETE("/delete/product/:productId", handler.DeleteProductHandler)
-	r.DELETE("/delete/order/:orderId", handler.DeleteOrderHandler)
+	orderReads := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.RoleCustomer, structs.ScopeOrderRead, structs.ScopeOrderWrite, structs.ScopeAdmin))
+	orderReads.GET("/order/:orderId", h.GetOrderHandler)
+	orderReads.GET("/order/:orderId/status/history", h.GetOrderStatusHistoryHandler)
+
+	orders := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.RoleCustomer, structs.ScopeOrderWrite, structs.ScopeAdmin))
+	orders.POST("/order", h.AddOrderHandler)
+	orders.POST("/order/:orderId/cancel", h.CancelOrderHandler)
+	orders.POST("/cart", h.AddCartHandler)
+	orders.GET("/cart/:cartId", h.GetCartHandler)
+	orders.PUT("/cart/:cartId/item/:productId", h.SetCartItemHandler)
+	orders.DELETE("/cart/:cartId/item/:productId", h.DeleteCartItemHandler)
+	orders.POST("/cart/:cartId/reservation", h.ReserveCartHandler)
+	orders.DELETE("/cart/:cartId/reservation", h.ReleaseCartHandler)
+	orders.POST("/cart/:cartId/checkout", h.CheckoutHandler)
+
+	staff := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.ScopeAdmin))
+	staff.POST("/customer", h.AddCustomerHandler)
+
+	staffOrders := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.ScopeOrderWrite, structs.ScopeAdmin))
+	staffOrders.PUT("/order/:orderId", h.UpdateOrderHandler)
+	staffOrders.PATCH("/order/:orderId/status", h.ChangeOrderStatusHandler)
+
+	adminOrders := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.ScopeOrderRead, structs.ScopeOrderWrite, structs.ScopeAdmin))
+	adminOrders.GET("/order", h.GetAllOrdersHandler)
+
+	adminCatalog := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.ScopeCatalogRead, structs.ScopeAdmin))
+	adminCatalog.GET("/admin/product/archived", h.GetArchivedProductsHandler)
+
+	catalog := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.ScopeCatalogWrite, structs.ScopeAdmin))
+	catalog.POST("/product", h.AddProductHandler)
+	catalog.PUT("/product/:productId", h.UpdateProductHandler)
+
+	admin := authorized.Group("/", handler.RequireAccess(structs.RoleAdmin, structs.ScopeAdmin))
+	admin.DELETE("/delete/product/:productId", h.DeleteProductHandler)
+	admin.DELETE("/delete/order/:orderId", h.DeleteOrderHandler)
+	admin.POST("/admin/product/:productId/restore", h.RestoreProductHandler)
+	admin.POST("/admin/user", h.AddUserHandler)
+	admin.POST("/admin/coupon", h.AddCouponHandler)
+	admin.GET("/admin/coupon", h.GetAllCouponsHandler)
+	admin.POST("/admin/promotion", h.AddPromotionHandler)
+	admin.GET("/admin/promotion", h.GetAllPromotionsHandler)
+	admin.PUT("/admin/promotion/:promotionId", h.UpdatePromotionHandler)
+	admin.POST("/admin/apikey", h.AddAPIKeyHandler)
+	admin.GET("/admin/apikey", h.GetAllAPIKeysHandler)
+	admin.DELETE("/admin/apikey/:apiKeyId", h.RevokeAPIKeyHandler)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	log.Println("Listening to port 8080...")
 	log.Fatal(r.Run(":8080"))
 }
+
+// migrate runs the migrate subcommand:
+//
+//	migrate [up]         apply all pending migrations
+//	migrate down [steps] revert the last steps migrations, 1 by default
+//	migrate version      print the current schema version
+func migrate(repository *database.SqlRepository, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := repository.MigrateUp(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number, got: %s", args[1])
+			}
+		}
+		if err := repository.MigrateDown(steps); err != nil {
+			return err
+		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
+
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	log.Printf("Schema is at version %d", version)
+
+	return nil
+}
@@ -1 +1,563 @@
 package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-rest-shop-backend/pkg/structs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenLifetime is how long an access token handed out on login stays valid.
+const tokenLifetime = 24 * time.Hour
+
+// claimsKey is the key the claims of an authenticated request are kept under in its context.
+const claimsKey = "claims"
+
+// apiKeyHeader is the header other systems send their API key in.
+const apiKeyHeader = "X-API-Key"
+
+// Claims are the contents of the access tokens of the shop. The subject is the
+// id of the user. Requests with an API key get claims with the id of the key
+// as subject, its scopes and no role. TokenVersion is the token version of
+// the user when the token was issued.
+type Claims struct {
+	Role         string   `json:"role"`
+	CustomerId   string   `json:"customer_id,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	TokenVersion int      `json:"token_version"`
+	jwt.StandardClaims
+}
+
+func (h *Handler) issueToken(user *structs.User) (*structs.Token, error) {
+	issuedAt := time.Now()
+	expiresAt := issuedAt.Add(tokenLifetime)
+
+	claims := Claims{
+		Role:         user.Role,
+		CustomerId:   user.CustomerId,
+		TokenVersion: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.secret)
+	if err != nil {
+		return nil, fmt.Errorf("signing token failed with: %s", err)
+	}
+
+	return &structs.Token{Token: signed, ExpiresAt: expiresAt.UTC()}, nil
+}
+
+func (h *Handler) parseToken(value string) (*Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(value, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return h.secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	return &claims, nil
+}
+
+// Authenticate is middleware that rejects requests without a valid bearer
+// token or API key and keeps the claims of the request for the handlers.
+// Tokens issued before the password of the user was changed are rejected.
+func (h *Handler) Authenticate(c *gin.Context) {
+	if secret := c.GetHeader(apiKeyHeader); secret != "" {
+		h.authenticateAPIKey(c, secret)
+		return
+	}
+
+	value := c.GetHeader("Authorization")
+	if !strings.HasPrefix(value, "Bearer ") {
+		unauthorized(c, fmt.Errorf("missing bearer token"))
+		return
+	}
+
+	claims, err := h.parseToken(strings.TrimPrefix(value, "Bearer "))
+	if err != nil {
+		unauthorized(c, err)
+		return
+	}
+
+	if err = h.service.AuthenticateToken(claims.Subject, claims.TokenVersion); err != nil {
+		if strings.HasPrefix(err.Error(), "invalid token") {
+			unauthorized(c, err)
+			return
+		}
+
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set(claimsKey, claims)
+	c.Next()
+}
+
+func (h *Handler) authenticateAPIKey(c *gin.Context, secret string) {
+	key, err := h.service.AuthenticateAPIKey(secret)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid API key") {
+			unauthorized(c, err)
+			return
+		}
+
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Set(claimsKey, &Claims{Scopes: key.Scopes, StandardClaims: jwt.StandardClaims{Subject: key.ID}})
+	c.Next()
+}
+
+// RequireAccess is middleware that lets through only authenticated requests
+// of users with a role among the grants and of API keys with a scope among
+// them.
+func RequireAccess(grants ...string) gin.HandlerFunc {
+	// The admin role and the admin scope are both granted as "admin".
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, grant := range grants {
+		if !seen[grant] {
+			seen[grant] = true
+			unique = append(unique, grant)
+		}
+	}
+	grants = unique
+
+	return func(c *gin.Context) {
+		if claims := claimsOf(c); claims != nil {
+			for _, grant := range grants {
+				if claims.Role != "" && claims.Role == grant {
+					c.Next()
+					return
+				}
+
+				for _, scope := range claims.Scopes {
+					if scope == grant {
+						c.Next()
+						return
+					}
+				}
+			}
+		}
+
+		err := fmt.Errorf("forbidden: requires one of %s", strings.Join(grants, ", "))
+		c.String(http.StatusForbidden, err.Error())
+
+		c.AbortWithError(http.StatusForbidden, err)
+	}
+}
+
+func unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.String(http.StatusUnauthorized, err.Error())
+
+	c.AbortWithError(http.StatusUnauthorized, err)
+}
+
+// claimsOf returns the claims Authenticate kept for the request, nil when it
+// was not authenticated.
+func claimsOf(c *gin.Context) *Claims {
+	value, ok := c.Get(claimsKey)
+	if !ok {
+		return nil
+	}
+
+	claims, _ := value.(*Claims)
+	return claims
+}
+
+// ownsCustomer tells whether the request may act for the customer. Staff,
+// admins and API keys act for every customer, customers only for themselves.
+func ownsCustomer(c *gin.Context, customerId string) bool {
+	claims := claimsOf(c)
+	if claims == nil {
+		return false
+	}
+
+	if claims.Role != structs.RoleCustomer {
+		return true
+	}
+
+	return customerId != "" && claims.CustomerId == customerId
+}
+
+// authorizeOrder answers with 404 and returns false when the request may not
+// act on the order, so that customers cannot learn about orders of others.
+func (h *Handler) authorizeOrder(c *gin.Context, orderId string) bool {
+	if claims := claimsOf(c); claims != nil && claims.Role != structs.RoleCustomer {
+		return true
+	}
+
+	order, err := h.service.GetOrderById(orderId, "")
+	if err == nil && ownsCustomer(c, order.CustomerId) {
+		return true
+	}
+
+	err = fmt.Errorf("no order with id: %s", orderId)
+	c.String(http.StatusNotFound, err.Error())
+
+	c.AbortWithError(http.StatusNotFound, err)
+	return false
+}
+
+// authorizeCart answers with 404 and returns false when the request may not
+// act on the cart, so that customers cannot learn about carts of others.
+func (h *Handler) authorizeCart(c *gin.Context, cartId string) bool {
+	if claims := claimsOf(c); claims != nil && claims.Role != structs.RoleCustomer {
+		return true
+	}
+
+	cart, err := h.service.GetCart(cartId)
+	if err == nil && ownsCustomer(c, cart.CustomerId) {
+		return true
+	}
+
+	err = fmt.Errorf("no cart with id: %s", cartId)
+	c.String(http.StatusNotFound, err.Error())
+
+	c.AbortWithError(http.StatusNotFound, err)
+	return false
+}
+
+// @Summary Sign in and get an access token
+// @Tags         Auth
+// @Accept   application/json
+// @Param   credentials	body   structs.LoginRequest	true  "Email and password of the account"
+// @Produce  application/json
+// @Success 200 {object} structs.Token
+// @Failure 400 {string} string "Request has wrong format"
+// @Failure 401 {string} string "Wrong email or password"
+// @Failure 500 {string} string "Internal server error"
+// @Router /login [post]
+func (h *Handler) LoginHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.LoginRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.service.Login(request.Email, request.Password)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid credentials") {
+			status = http.StatusUnauthorized
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	token, err := h.issueToken(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// @Summary Create an account
+// @Description Customer accounts need the customer_id of an existing customer.
+// @Tags         Admin
+// @Accept   application/json
+// @Param   user	body   structs.UserRequest	true  "New account details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format, unknown role or too short password"
+// @Failure 409 {string} string "Account with such email already exists"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/user [post]
+func (h *Handler) AddUserHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.UserRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	user := structs.User{Email: request.Email, Role: request.Role, CustomerId: request.CustomerId}
+
+	userID, err := h.service.AddUser(&user, request.Password)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid user") || strings.HasPrefix(err.Error(), "invalid password") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "user with email") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "User successfully added id: %s", userID)
+}
+
+// @Summary Register as a customer and get an access token
+// @Tags         Auth
+// @Accept   application/json
+// @Param   account	body   structs.RegisterRequest	true  "Customer and account details"
+// @Produce  application/json
+// @Success 200 {object} structs.Token
+// @Failure 400 {string} string "Request has wrong format, misses details or has a too short password"
+// @Failure 409 {string} string "Account with such email already exists"
+// @Failure 500 {string} string "Internal server error"
+// @Router /register [post]
+func (h *Handler) RegisterHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.RegisterRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	customer := structs.Customer{Name: request.Name, Address: request.Address, Phone: request.Phone}
+
+	user, err := h.service.Register(&customer, request.Email, request.Password)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "user with email") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	token, err := h.issueToken(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// @Summary Change the password of the signed in account
+// @Description Tokens issued before, the one of this request included, stop working. Sign in again with the new password.
+// @Tags         Auth
+// @Accept   application/json
+// @Param   passwords	body   structs.PasswordChangeRequest	true  "Current and new password"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or too short password"
+// @Failure 401 {string} string "Current password is wrong"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Router /account/password [put]
+func (h *Handler) ChangePasswordHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.PasswordChangeRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.ChangePassword(claimsOf(c).Subject, request.CurrentPassword, request.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid password") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "invalid credentials") || strings.HasPrefix(err.Error(), "no user") {
+			status = http.StatusUnauthorized
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Password changed")
+}
+
+// @Summary Request a password reset token
+// @Description The token is sent to the email of the account. The response is the same whether such an account exists or not.
+// @Tags         Auth
+// @Accept   application/json
+// @Param   account	body   structs.PasswordResetRequest	true  "Email of the account"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format"
+// @Failure 500 {string} string "Internal server error"
+// @Router /password/reset [post]
+func (h *Handler) RequestPasswordResetHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.PasswordResetRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(request.Email); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.String(http.StatusOK, "If an account with this email exists, a reset token was sent to it")
+}
+
+// @Summary Set a new password with a reset token
+// @Description Tokens issued before the reset stop working.
+// @Tags         Auth
+// @Accept   application/json
+// @Param   reset	body   structs.PasswordResetConfirmRequest	true  "Reset token and new password"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format, invalid or expired token or too short password"
+// @Failure 500 {string} string "Internal server error"
+// @Router /password/reset/confirm [post]
+func (h *Handler) ResetPasswordHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.PasswordResetConfirmRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.ResetPassword(request.Token, request.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Password changed")
+}
+
+// @Summary Issue an API key for another system
+// @Description The key is in the response only, store it right away. Scopes are catalog:read, catalog:write (add and update products), orders:read, orders:write and admin.
+// @Tags         Admin
+// @Accept   application/json
+// @Param   key	body   structs.APIKeyRequest	true  "Name and scopes of the key"
+// @Produce  application/json
+// @Success 200 {object} structs.IssuedAPIKey
+// @Failure 400 {string} string "Request has wrong format, misses the name or has an unknown scope"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/apikey [post]
+func (h *Handler) AddAPIKeyHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.APIKeyRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := h.service.IssueAPIKey(request.Name, request.Scopes)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid API key") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// @Summary Get all API keys
+// @Description Revoked keys are listed with the time they were revoked.
+// @Tags         Admin
+// @Produce  application/json
+// @Success 200 {array} structs.APIKey
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/apikey [get]
+func (h *Handler) GetAllAPIKeysHandler(c *gin.Context) {
+	keys, err := h.service.GetAllAPIKeys()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary Revoke an API key
+// @Tags         Admin
+// @Param   apiKeyId		path   string    true  "ID of the API key"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 404 {string} string "API key does not exist"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/apikey/{apiKeyId} [delete]
+func (h *Handler) RevokeAPIKeyHandler(c *gin.Context) {
+	keyId := c.Param("apiKeyId")
+
+	if err := h.service.RevokeAPIKey(keyId); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no API key") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "API key %s revoked", keyId)
+}
@@ -2,62 +2,297 @@ package pkg
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"os"
+	"strings"
+	"time"
 )
 
-var db *sql.DB
+// SqlRepository implements ProductRepository and OrderRepository on top of a
+// database/sql connection. Queries are written with ? placeholders and are
+// rewritten for the dialect of the connection.
+type SqlRepository struct {
+	conn    *sql.DB
+	db      queryer
+	dialect Dialect
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so the same queries run
+// inside and outside of a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func NewSqlRepository(db *sql.DB, dialect Dialect) *SqlRepository {
+	return &SqlRepository{conn: db, db: db, dialect: dialect}
+}
+
+// Repositories exposes the repository as the storage dependencies of the service layer.
+func (r *SqlRepository) Repositories() Repositories {
+	return Repositories{
+		Products:     r,
+		Orders:       r,
+		Customers:    r,
+		Users:        r,
+		APIKeys:      r,
+		Carts:        r,
+		Reservations: r,
+		Coupons:      r,
+		Promotions:   r,
+	}
+}
+
+// WithinTransaction runs fn with repositories bound to a single transaction,
+// committing it when fn succeeds and rolling it back otherwise. Calls on a
+// repository that is already part of a transaction join that transaction.
+func (r *SqlRepository) WithinTransaction(fn func(repos Repositories) error) error {
+	if _, ok := r.db.(*sql.Tx); ok {
+		return fn(r.Repositories())
+	}
+
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction failed with: %s", err)
+	}
+
+	txRepository := &SqlRepository{conn: r.conn, db: tx, dialect: r.dialect}
+	if err = fn(txRepository.Repositories()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction failed with: %s", err)
+	}
+
+	return nil
+}
+
+// InitConnection opens the database selected by the DB_DRIVER environment
+// variable. MySQL is used when the variable is not set.
+func InitConnection() (*SqlRepository, error) {
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	dialect := Dialect(os.Getenv("DB_DRIVER"))
+	switch dialect {
+	case "", MySql:
+		dialect = MySql
+		db, err = InitMySqlConnection()
+	case Sqlite:
+		db, err = InitSqliteConnection()
+	case Postgres:
+		db, err = InitPostgresConnection()
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	repository := NewSqlRepository(db, dialect)
+
+	// A local SQLite file is brought up to date on open, so development
+	// needs no separate migrate step.
+	if dialect == Sqlite {
+		if err = repository.MigrateUp(); err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty Postgres database gets the shop tables on open, later
+	// migrations of an existing one are left to the migrate command.
+	if dialect == Postgres {
+		version, err := repository.SchemaVersion()
+		if err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			if err = repository.MigrateUp(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return repository, nil
+}
 
-func InitMySqlConnection() error {
+func InitMySqlConnection() (*sql.DB, error) {
 
 	config := mysql.Config{
-		User:   os.Getenv("MYSQL_USER"),
-		Passwd: os.Getenv("MYSQL_PASSWORD"),
-		Net:    "tcp",
-		Addr:   os.Getenv("MYSQL_IP_ADDRESS"),
-		DBName: "online_shop",
+		User:      os.Getenv("MYSQL_USER"),
+		Passwd:    os.Getenv("MYSQL_PASSWORD"),
+		Net:       "tcp",
+		Addr:      os.Getenv("MYSQL_IP_ADDRESS"),
+		DBName:    "online_shop",
+		ParseTime: true,
+		// Rows affected counts the rows matched, as on the other databases,
+		// also when an UPDATE leaves them as they were.
+		ClientFoundRows: true,
 	}
 
-	var err error
-	db, err = sql.Open("mysql", config.FormatDSN())
+	db, err := sql.Open("mysql", config.FormatDSN())
 	if err != nil {
-		return fmt.Errorf("database opening failed with error: %s", err.Error())
+		return nil, fmt.Errorf("database opening failed with error: %s", err.Error())
 	}
 
 	pingErr := db.Ping()
 	if pingErr != nil {
-		return fmt.Errorf("ping my sql failed with error: %s", err.Error())
+		return nil, fmt.Errorf("ping my sql failed with error: %s", pingErr.Error())
 	}
 
-	return nil
+	return db, nil
 }
 
-func GetAllProducts() ([]Product, error) {
-	var products []Product
+// productColumns are the columns of products in the order scanProduct reads them.
+const productColumns = "id, name, category, quantity, reserved, price_amount, price_currency, deleted_at, version, created_at, updated_at"
+
+// orderColumns are the columns of orders in the order scanOrder reads them.
+const orderColumns = "id, name, address, phone, price_amount, price_currency, status, version, created_at, updated_at, customer_id, subtotal_amount"
+
+// now is the time stored by writes, in UTC and cut to the microseconds every
+// supported database keeps, so that read values compare equal to written ones.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProduct(row scanner) (Product, error) {
+	var p Product
+	var deletedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.Name, &p.Category, &p.Quantity, &p.Reserved, &p.Price.Amount, &p.Price.Currency, &deletedAt, &p.Version, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return p, err
+	}
+
+	if deletedAt.Valid {
+		p.DeletedAt = &deletedAt.Time
+	}
+
+	return p, nil
+}
+
+func scanOrder(row scanner) (Order, error) {
+	var o Order
+	var customerId sql.NullString
+	err := row.Scan(&o.ID, &o.Name, &o.Address, &o.Phone, &o.Price.Amount, &o.Price.Currency, &o.Status, &o.Version, &o.CreatedAt, &o.UpdatedAt, &customerId, &o.Subtotal.Amount)
+	o.CustomerId = customerId.String
+	o.Subtotal.Currency = o.Price.Currency
+	return o, err
+}
+
+// productSortColumns maps the sort keys of the catalog to their columns.
+var productSortColumns = map[string]string{
+	"price":    "price_amount",
+	"name":     "name",
+	"quantity": "quantity",
+}
+
+// GetAllProducts reads one page of the catalog, or of the archived products
+// when query.Archived is set. Filters and sorting are done by the database,
+// products with the same sort value are ordered by id.
+func (r *SqlRepository) GetAllProducts(query ProductQuery) (*ProductPage, error) {
+	column, ok := productSortColumns[query.SortBy]
+	if !ok && query.SortBy != "" {
+		return nil, fmt.Errorf("invalid sort: %s", query.SortBy)
+	}
+
+	sort := query.SortBy
+	if query.Descending {
+		sort = "-" + sort
+	}
+
+	after, err := decodeCursor(query.Cursor, sort)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	if query.Archived {
+		conditions = []string{"deleted_at IS NOT NULL"}
+	}
+
+	var args []interface{}
+	if query.Category != "" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, query.Category)
+	}
+	if query.MinPrice != nil {
+		conditions = append(conditions, "price_amount >= ?")
+		args = append(args, *query.MinPrice)
+	}
+	if query.MaxPrice != nil {
+		conditions = append(conditions, "price_amount <= ?")
+		args = append(args, *query.MaxPrice)
+	}
+	if query.PriceCurrency != "" {
+		conditions = append(conditions, "price_currency = ?")
+		args = append(args, query.PriceCurrency)
+	}
+	if query.InStock {
+		conditions = append(conditions, "quantity > reserved")
+	}
+	if query.Name != "" {
+		conditions = append(conditions, "LOWER(name) LIKE ? ESCAPE '!'")
+		args = append(args, likePattern(query.Name))
+	}
+
+	page := ProductPage{Products: []Product{}}
+	if err = r.queryRow("SELECT COUNT(*) FROM products"+whereClause(conditions), args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("error while counting products in database: %s", err)
+	}
+
+	orderBy, condition, keysetArgs := keyset(column, query.Descending, after)
+	if condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, keysetArgs...)
+	}
 
-	rows, err := db.Query("SELECT * FROM products")
+	rows, err := r.query("SELECT "+productColumns+" FROM products"+whereClause(conditions)+orderBy+" LIMIT ?", append(args, query.Limit+1)...)
 	if err != nil {
 		return nil, fmt.Errorf("error while reading all products from database: %s", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var p Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.Quantity, &p.Price); err != nil {
+		p, err := scanProduct(rows)
+		if err != nil {
 			return nil, fmt.Errorf("parsing to a product failed with: %v", err)
 		}
-		products = append(products, p)
+		page.Products = append(page.Products, p)
 	}
 
-	return products, nil
-}
+	if len(page.Products) > query.Limit {
+		page.Products = page.Products[:query.Limit]
 
-func GetProductById(productId string) (*Product, error) {
-	row := db.QueryRow("SELECT * FROM products WHERE id = ?", productId)
+		last := page.Products[query.Limit-1]
+		next := cursor{Sort: sort, ID: last.ID}
+		switch query.SortBy {
+		case "price":
+			next.Value = last.Price.Amount
+		case "name":
+			next.Value = last.Name
+		case "quantity":
+			next.Value = last.Quantity
+		}
+		page.NextCursor = encodeCursor(next)
+	}
 
-	var p Product
-	if err := row.Scan(&p.ID, &p.Name, &p.Category, &p.Quantity, &p.Price); err != nil {
+	return &page, nil
+}
+
+func (r *SqlRepository) GetProductById(productId string) (*Product, error) {
+	p, err := scanProduct(r.queryRow("SELECT "+productColumns+" FROM products WHERE id = ?", productId))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no product with id: %s", productId)
 		}
@@ -67,177 +302,443 @@ func GetProductById(productId string) (*Product, error) {
 	return &p, nil
 }
 
-func GetAllOrders() ([]Order, error) {
-	var orders []Order
+// orderSortColumns maps the sort keys of the order listing to their columns.
+var orderSortColumns = map[string]string{
+	"created_at": "created_at",
+}
+
+// GetAllOrders reads one page of orders, ordered by id or creation time, and
+// then the products of all of them at once, a fixed number of queries no
+// matter the page size.
+func (r *SqlRepository) GetAllOrders(query OrderQuery) (*OrderPage, error) {
+	column, ok := orderSortColumns[query.SortBy]
+	if !ok && query.SortBy != "" {
+		return nil, fmt.Errorf("invalid sort: %s", query.SortBy)
+	}
+
+	sort := query.SortBy
+	if query.Descending {
+		sort = "-" + sort
+	}
+
+	after, err := decodeCursor(query.Cursor, sort)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil && column != "" {
+		value, _ := after.Value.(string)
+		createdAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %s", query.Cursor)
+		}
+		after.Value = createdAt
+	}
+
+	var conditions []string
+	var args []interface{}
+	if query.CustomerId != "" {
+		conditions = append(conditions, "customer_id = ?")
+		args = append(args, query.CustomerId)
+	}
+	if query.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if query.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, query.To.UTC())
+	}
+
+	page := OrderPage{Orders: []Order{}}
+	if err = r.queryRow("SELECT COUNT(*) FROM orders"+whereClause(conditions), args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("error while counting orders in database: %s", err)
+	}
 
-	rows, _ := db.Query("SELECT * FROM orders")
+	orderBy, condition, keysetArgs := keyset(column, query.Descending, after)
+	if condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, keysetArgs...)
+	}
+
+	rows, err := r.query("SELECT "+orderColumns+" FROM orders"+whereClause(conditions)+orderBy+" LIMIT ?", append(args, query.Limit+1)...)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading all orders from database: %s", err)
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var o Order
-		if err := rows.Scan(&o.ID, &o.Name, &o.Address, &o.Phone, &o.Price, &o.Status); err != nil {
+		o, err := scanOrder(rows)
+		if err != nil {
 			return nil, fmt.Errorf("getting all products failed with: %v", err)
 		}
 
-		products, err := GetAllProductsForOrder(o.ID)
-		if err != nil {
-			return nil, err
+		page.Orders = append(page.Orders, o)
+	}
+	rows.Close()
+
+	if len(page.Orders) > query.Limit {
+		page.Orders = page.Orders[:query.Limit]
+
+		last := page.Orders[query.Limit-1]
+		next := cursor{Sort: sort, ID: last.ID}
+		if column != "" {
+			next.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
 		}
-		o.Products = products
+		page.NextCursor = encodeCursor(next)
+	}
+
+	if err = r.loadProductsForOrders(page.Orders); err != nil {
+		return nil, err
+	}
 
-		orders = append(orders, o)
+	if err = r.loadDiscountsForOrders(page.Orders); err != nil {
+		return nil, err
 	}
 
-	return orders, nil
+	return &page, nil
 }
 
-func GetOrderById(orderId string) (*Order, error) {
-	row := db.QueryRow("SELECT * FROM orders WHERE id = ?", orderId)
+// loadProductsForOrders fills in the products of the given orders with a
+// single query, using the details captured when the orders were placed.
+func (r *SqlRepository) loadProductsForOrders(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(orders))
+	byId := make(map[string]*Order, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		byId[orders[i].ID] = &orders[i]
+	}
 
-	var o Order
-	if err := row.Scan(&o.ID, &o.Name, &o.Address, &o.Phone, &o.Price, &o.Status); err != nil {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := r.query("SELECT order_id, product_id, quantity, name, category, price_amount, price_currency FROM orderedProduct WHERE order_id IN ("+placeholders+")", ids...)
+	if err != nil {
+		return fmt.Errorf("error while reading ordered product from database: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderId string
+		var p Product
+		if err := rows.Scan(&orderId, &p.ID, &p.Quantity, &p.Name, &p.Category, &p.Price.Amount, &p.Price.Currency); err != nil {
+			return fmt.Errorf("parsing to a product failed with: %v", err)
+		}
+
+		if o, ok := byId[orderId]; ok {
+			o.Products = append(o.Products, p)
+		}
+	}
+
+	return rows.Err()
+}
+
+// loadDiscountsForOrders fills in the discounts of the given orders with a
+// single query.
+func (r *SqlRepository) loadDiscountsForOrders(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(orders))
+	byId := make(map[string]*Order, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		byId[orders[i].ID] = &orders[i]
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := r.query("SELECT id, order_id, position, source, code, description, amount, currency FROM order_discounts WHERE order_id IN ("+placeholders+") ORDER BY position", ids...)
+	if err != nil {
+		return fmt.Errorf("error while reading order discounts from database: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d OrderDiscount
+		if err := rows.Scan(&d.ID, &d.OrderId, &d.Position, &d.Source, &d.Code, &d.Description, &d.Amount.Amount, &d.Amount.Currency); err != nil {
+			return fmt.Errorf("parsing to an order discount failed with: %v", err)
+		}
+
+		if o, ok := byId[d.OrderId]; ok {
+			o.Discounts = append(o.Discounts, d)
+			if d.Source == DiscountCoupon {
+				o.CouponCode = d.Code
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *SqlRepository) GetOrderById(orderId string) (*Order, error) {
+	o, err := scanOrder(r.queryRow("SELECT "+orderColumns+" FROM orders WHERE id = ?", orderId))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no order with id: %s", orderId)
 		}
 		return nil, fmt.Errorf("searching for %s failed with: %s", orderId, err)
 	}
 
-	products, err := GetAllProductsForOrder(o.ID)
+	products, err := r.GetAllProductsForOrder(o.ID)
 	if err != nil {
 		return nil, err
 	}
 	o.Products = products
 
-	return &o, nil
+	orders := []Order{o}
+	if err = r.loadDiscountsForOrders(orders); err != nil {
+		return nil, err
+	}
+
+	return &orders[0], nil
 }
 
-func AddProduct(product *Product) (string, error) {
-	id, err := uuid.NewV4()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate uuid error: %s", err)
-	}
+func (r *SqlRepository) AddProduct(product *Product) (string, error) {
+	product.CreatedAt = now()
+	product.UpdatedAt = product.CreatedAt
 
-	_, err = db.Query("INSERT INTO products (ID, NAME, CATEGORY, QUANTITY, PRICE) VALUES (?,?,?,?,?)", id.String(), product.Name, product.Category, product.Quantity, product.Price)
+	id, err := r.insert("products", "NAME, CATEGORY, QUANTITY, PRICE_AMOUNT, PRICE_CURRENCY, CREATED_AT, UPDATED_AT", product.Name, product.Category, product.Quantity, product.Price.Amount, product.Price.Currency, product.CreatedAt, product.UpdatedAt)
 	if err != nil {
 		return "", fmt.Errorf("failed to add product to the database, error: %s", err)
 	}
 
-	return id.String(), nil
+	return id, nil
 }
 
-func AddOrder(order *Order) (string, error) {
-	id, err := uuid.NewV4()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate uuid error: %s", err)
-	}
+func (r *SqlRepository) AddOrder(order *Order) (string, error) {
+	order.CreatedAt = now()
+	order.UpdatedAt = order.CreatedAt
 
-	_, err = db.Query("INSERT INTO orders (ID, NAME, Address, Phone, Price, Status) VALUES (?,?,?,?,?,?)", id.String(), order.Name, order.Address, order.Phone, order.Price, order.Status)
+	customerId := sql.NullString{String: order.CustomerId, Valid: order.CustomerId != ""}
+	id, err := r.insert("orders", "NAME, Address, Phone, PRICE_AMOUNT, PRICE_CURRENCY, SUBTOTAL_AMOUNT, Status, CREATED_AT, UPDATED_AT, CUSTOMER_ID", order.Name, order.Address, order.Phone, order.Price.Amount, order.Price.Currency, order.Subtotal.Amount, order.Status, order.CreatedAt, order.UpdatedAt, customerId)
 	if err != nil {
 		return "", fmt.Errorf("failed to add order to the database, error: %s", err)
 	}
 
-	return id.String(), nil
+	return id, nil
 }
 
-func UpdateProduct(product *Product) error {
+// UpdateProduct overwrites the product only while it is still at
+// product.Version, and moves it to the next version. The quantity cannot be
+// set below the stock reserved for carts.
+func (r *SqlRepository) UpdateProduct(product *Product) error {
 
-	result, err := db.Exec("UPDATE products SET NAME = ?, CATEGORY = ?, QUANTITY = ?, PRICE = ? WHERE ID = ?", product.Name, product.Category, product.Quantity, product.Price, product.ID)
+	updatedAt := now()
+	result, err := r.exec("UPDATE products SET NAME = ?, CATEGORY = ?, QUANTITY = ?, PRICE_AMOUNT = ?, PRICE_CURRENCY = ?, version = version + 1, updated_at = ? WHERE ID = ? AND version = ? AND reserved <= ?", product.Name, product.Category, product.Quantity, product.Price.Amount, product.Price.Currency, updatedAt, product.ID, product.Version, product.Quantity)
 	if err != nil {
 		return fmt.Errorf("failed to update product to the database, error: %s", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("no product with id: %s", product.ID)
+		stored, err := r.GetProductById(product.ID)
+		if err != nil {
+			return err
+		}
+
+		if stored.Version != product.Version {
+			return fmt.Errorf("stale version %d of product %s, it is at version %d", product.Version, product.ID, stored.Version)
+		}
+
+		return fmt.Errorf("product %s has %d reserved for carts, its quantity cannot be %d", product.ID, stored.Reserved, product.Quantity)
 	}
 
+	product.Version++
+	product.UpdatedAt = updatedAt
+
 	return nil
 }
 
-func UpdateOrder(order *Order) error {
+// UpdateOrder overwrites the order only while it is still at order.Version,
+// and moves it to the next version.
+func (r *SqlRepository) UpdateOrder(order *Order) error {
 
-	result, err := db.Exec("UPDATE orders SET NAME = ?, ADDRESS = ?, PHONE = ?, PRICE = ? WHERE ID = ?", order.Name, order.Address, order.Phone, order.Price, order.ID)
+	updatedAt := now()
+	result, err := r.exec("UPDATE orders SET NAME = ?, ADDRESS = ?, PHONE = ?, PRICE_AMOUNT = ?, PRICE_CURRENCY = ?, SUBTOTAL_AMOUNT = ?, version = version + 1, updated_at = ? WHERE ID = ? AND version = ?", order.Name, order.Address, order.Phone, order.Price.Amount, order.Price.Currency, order.Subtotal.Amount, updatedAt, order.ID, order.Version)
 	if err != nil {
 		return fmt.Errorf("failed to update order to the database, error: %s", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("no product with id: %s", order.ID)
+		var version int
+		err = r.queryRow("SELECT version FROM orders WHERE ID = ?", order.ID).Scan(&version)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no order with id: %s", order.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("reading order version failed with: %s", err)
+		}
+
+		return fmt.Errorf("stale version %d of order %s, it is at version %d", order.Version, order.ID, version)
 	}
 
+	order.Version++
+	order.UpdatedAt = updatedAt
+
 	return nil
 }
 
-func DeleteOrder(orderId string) error {
-	result, err := db.Exec("DELETE FROM orders WHERE ID = ?;", orderId)
+// UpdateOrderStatus moves an order from one status to another. The update only
+// applies while the order still has the expected status, so two concurrent
+// changes of the same order cannot both succeed.
+func (r *SqlRepository) UpdateOrderStatus(orderId string, from string, to string) error {
+	result, err := r.exec("UPDATE orders SET STATUS = ?, version = version + 1, updated_at = ? WHERE ID = ? AND STATUS = ?", to, now(), orderId, from)
 	if err != nil {
-		return fmt.Errorf("failed to delete order from the database, error: %s", err)
+		return fmt.Errorf("failed to update order status in the database, error: %s", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("no order with id: %d", orderId)
+		return fmt.Errorf("order %s is no longer %s", orderId, from)
 	}
 
 	return nil
 }
 
-func DeleteAllProductsForAnOrder(orderId string) error {
-	result, err := db.Exec("DELETE FROM orderedProduct WHERE ORDER_ID = ?;", orderId)
+func (r *SqlRepository) DeleteOrder(orderId string) error {
+	result, err := r.exec("DELETE FROM orders WHERE ID = ?;", orderId)
 	if err != nil {
-		return fmt.Errorf("failed to delete ordered product from the database, error: %s", err)
+		return fmt.Errorf("failed to delete order from the database, error: %s", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("no order with id: %d", orderId)
+		return fmt.Errorf("no order with id: %s", orderId)
+	}
+
+	return nil
+}
+
+func (r *SqlRepository) DeleteAllProductsForAnOrder(orderId string) error {
+	if _, err := r.exec("DELETE FROM orderedProduct WHERE ORDER_ID = ?;", orderId); err != nil {
+		return fmt.Errorf("failed to delete ordered product from the database, error: %s", err)
 	}
 
 	return nil
 }
 
-func DeleteProduct(productId string) error {
-	result, err := db.Exec("DELETE FROM products WHERE ID = ?;", productId)
+// DeleteProduct archives the product. It disappears from the catalog, but
+// stays in the database for the orders that refer to it.
+func (r *SqlRepository) DeleteProduct(productId string) error {
+	deletedAt := now()
+	result, err := r.exec("UPDATE products SET deleted_at = ?, version = version + 1, updated_at = ? WHERE ID = ? AND deleted_at IS NULL", deletedAt, deletedAt, productId)
 	if err != nil {
 		return fmt.Errorf("failed to delete product from the database, error: %s", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("no product with id: %d", productId)
+		return fmt.Errorf("no product with id: %s", productId)
 	}
 
 	return nil
 }
 
-func ChangeProductQuantity(productId string, quantity int) error {
-	var p Product
-
-	row := db.QueryRow("SELECT * FROM products WHERE id = ?", productId)
-	if err := row.Scan(&p.ID, &p.Name, &p.Category, &p.Quantity, &p.Price); err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("no product with id: %s", productId)
-		}
-		return fmt.Errorf("searching for id: %s failed with: %s", productId, err)
+// RestoreProduct brings an archived product back into the catalog.
+func (r *SqlRepository) RestoreProduct(productId string) error {
+	result, err := r.exec("UPDATE products SET deleted_at = NULL, version = version + 1, updated_at = ? WHERE ID = ? AND deleted_at IS NOT NULL", now(), productId)
+	if err != nil {
+		return fmt.Errorf("failed to restore product in the database, error: %s", err)
 	}
 
-	newQuantity := p.Quantity - quantity
-	if newQuantity < 0 {
-		return fmt.Errorf("not enough quantity of product: %s", p.Name)
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no archived product with id: %s", productId)
 	}
 
-	if _, err := db.Query("UPDATE products SET quantity = ? WHERE id = ?", newQuantity, p.ID); err != nil {
+	return nil
+}
+
+// ChangeProductQuantity takes quantity items of the product out of stock. The
+// check and the decrement are a single conditional UPDATE, so concurrent orders
+// can never drive the stock below zero or into stock reserved for carts.
+// Archived products cannot be ordered.
+func (r *SqlRepository) ChangeProductQuantity(productId string, quantity int) error {
+	result, err := r.exec("UPDATE products SET quantity = quantity - ?, version = version + 1, updated_at = ? WHERE id = ? AND quantity - reserved >= ? AND deleted_at IS NULL", quantity, now(), productId, quantity)
+	if err != nil {
 		return fmt.Errorf("updating quantity failed with: %s", err)
 	}
 
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		return nil
+	}
+
+	p, err := r.GetProductById(productId)
+	if err != nil {
+		return err
+	}
+
+	if p.DeletedAt != nil {
+		return fmt.Errorf("archived product: %s", p.Name)
+	}
+
+	return fmt.Errorf("not enough quantity of product: %s", p.Name)
+}
+
+// ReserveProduct holds quantity items of the product. Like
+// ChangeProductQuantity it never holds more than the stock that is neither
+// ordered nor reserved. Reserving does not change the version of the product.
+func (r *SqlRepository) ReserveProduct(productId string, quantity int) error {
+	result, err := r.exec("UPDATE products SET reserved = reserved + ? WHERE id = ? AND quantity - reserved >= ? AND deleted_at IS NULL", quantity, productId, quantity)
+	if err != nil {
+		return fmt.Errorf("reserving product failed with: %s", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		return nil
+	}
+
+	p, err := r.GetProductById(productId)
+	if err != nil {
+		return err
+	}
+
+	if p.DeletedAt != nil {
+		return fmt.Errorf("archived product: %s", p.Name)
+	}
+
+	return fmt.Errorf("not enough quantity of product: %s", p.Name)
+}
+
+// ReleaseProduct stops holding quantity items of the product.
+func (r *SqlRepository) ReleaseProduct(productId string, quantity int) error {
+	result, err := r.exec("UPDATE products SET reserved = reserved - ? WHERE id = ? AND reserved >= ?", quantity, productId, quantity)
+	if err != nil {
+		return fmt.Errorf("releasing product failed with: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no reserved quantity %d of product: %s", quantity, productId)
+	}
+
 	return nil
 }
 
-func GetAllProductsForOrder(orderId string) ([]Product, error) {
+// RestockProduct puts quantity items of the product back into stock.
+func (r *SqlRepository) RestockProduct(productId string, quantity int) error {
+	result, err := r.exec("UPDATE products SET quantity = quantity + ?, version = version + 1, updated_at = ? WHERE id = ?", quantity, now(), productId)
+	if err != nil {
+		return fmt.Errorf("restocking product failed with: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no product with id: %s", productId)
+	}
+
+	return nil
+}
+
+// GetAllProductsForOrder reads the products of an order with the name, category
+// and price they had when the order was placed.
+func (r *SqlRepository) GetAllProductsForOrder(orderId string) ([]Product, error) {
 	var products []Product
 
-	rows, err := db.Query("SELECT product_id, quantity FROM orderedProduct WHERE order_id = ?", orderId)
+	rows, err := r.query("SELECT product_id, quantity, name, category, price_amount, price_currency FROM orderedProduct WHERE order_id = ?", orderId)
 	if err != nil {
 		return nil, fmt.Errorf("error while reading ordered product from database: %s", err)
 	}
@@ -245,31 +746,836 @@ func GetAllProductsForOrder(orderId string) ([]Product, error) {
 
 	for rows.Next() {
 		var p Product
-		if err := rows.Scan(&p.ID, &p.Quantity); err != nil {
+		if err := rows.Scan(&p.ID, &p.Quantity, &p.Name, &p.Category, &p.Price.Amount, &p.Price.Currency); err != nil {
 			return nil, fmt.Errorf("parsing to a product failed with: %v", err)
 		}
 
-		details, err := GetProductById(p.ID)
-		if err != nil {
-			return nil, err
-		}
-		p.Name, p.Category, p.Price = details.Name, details.Category, details.Price
-
 		products = append(products, p)
 	}
 
 	return products, nil
 }
 
-func AddOrderedProduct(op *OrderedProduct) error {
-	id, err := uuid.NewV4()
+func (r *SqlRepository) AddOrderedProduct(op *OrderedProduct) error {
+	_, err := r.insert("orderedProduct", "PRODUCT_ID, QUANTITY, ORDER_ID, NAME, CATEGORY, PRICE_AMOUNT, PRICE_CURRENCY", op.ProductId, op.ProductQuantity, op.OrderId, op.Name, op.Category, op.Price.Amount, op.Price.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to add ordered product to the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteOrderedProduct removes every line of the product from the order.
+func (r *SqlRepository) DeleteOrderedProduct(orderId string, productId string) error {
+	result, err := r.exec("DELETE FROM orderedProduct WHERE ORDER_ID = ? AND PRODUCT_ID = ?", orderId, productId)
 	if err != nil {
-		return fmt.Errorf("failed to generate uuid error: %s", err)
+		return fmt.Errorf("failed to delete ordered product from the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no product %s in order %s", productId, orderId)
 	}
 
-	_, err = db.Query("INSERT INTO orderedProduct (ID, PRODUCT_ID, QUANTITY,  ORDER_ID) VALUES (?,?,?,?)", id.String(), op.ProductId, op.ProductQuantity, op.OrderId)
+	return nil
+}
+
+// AddOrderStatusChange records that the order moved to another status.
+func (r *SqlRepository) AddOrderStatusChange(change *OrderStatusChange) error {
+	change.ChangedAt = now()
+
+	from := sql.NullString{String: change.From, Valid: change.From != ""}
+	id, err := r.insert("order_status_history", "ORDER_ID, FROM_STATUS, TO_STATUS, CHANGED_AT", change.OrderId, from, change.To, change.ChangedAt)
 	if err != nil {
-		return fmt.Errorf("failed to add ordered product to the database, error: %s", err)
+		return fmt.Errorf("failed to add order status change to the database, error: %s", err)
+	}
+	change.ID = id
+
+	return nil
+}
+
+// GetOrderStatusHistory reads the status changes of an order, oldest first.
+func (r *SqlRepository) GetOrderStatusHistory(orderId string) ([]OrderStatusChange, error) {
+	rows, err := r.query("SELECT id, order_id, from_status, to_status, changed_at FROM order_status_history WHERE order_id = ? ORDER BY changed_at, id", orderId)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading order status history from database: %s", err)
+	}
+	defer rows.Close()
+
+	history := []OrderStatusChange{}
+	for rows.Next() {
+		var change OrderStatusChange
+		var from sql.NullString
+		if err := rows.Scan(&change.ID, &change.OrderId, &from, &change.To, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("parsing to an order status change failed with: %v", err)
+		}
+		change.From = from.String
+
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+func (r *SqlRepository) DeleteOrderStatusHistory(orderId string) error {
+	if _, err := r.exec("DELETE FROM order_status_history WHERE ORDER_ID = ?", orderId); err != nil {
+		return fmt.Errorf("failed to delete order status history from the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// customerColumns are the columns of customers in the order scanCustomer reads them.
+const customerColumns = "id, name, address, phone, created_at, updated_at"
+
+func scanCustomer(row scanner) (Customer, error) {
+	var c Customer
+	err := row.Scan(&c.ID, &c.Name, &c.Address, &c.Phone, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+func (r *SqlRepository) GetCustomerById(customerId string) (*Customer, error) {
+	c, err := scanCustomer(r.queryRow("SELECT "+customerColumns+" FROM customers WHERE id = ?", customerId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no customer with id: %s", customerId)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", customerId, err)
+	}
+
+	return &c, nil
+}
+
+func (r *SqlRepository) AddCustomer(customer *Customer) (string, error) {
+	customer.CreatedAt = now()
+	customer.UpdatedAt = customer.CreatedAt
+
+	id, err := r.insert("customers", "NAME, ADDRESS, PHONE, CREATED_AT, UPDATED_AT", customer.Name, customer.Address, customer.Phone, customer.CreatedAt, customer.UpdatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add customer to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+func (r *SqlRepository) UpdateCustomer(customer *Customer) error {
+	customer.UpdatedAt = now()
+
+	result, err := r.exec("UPDATE customers SET NAME = ?, ADDRESS = ?, PHONE = ?, UPDATED_AT = ? WHERE ID = ?", customer.Name, customer.Address, customer.Phone, customer.UpdatedAt, customer.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update customer in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no customer with id: %s", customer.ID)
+	}
+
+	return nil
+}
+
+// userColumns are the columns of users in the order scanUser reads them.
+const userColumns = "id, email, password_hash, role, customer_id, token_version, created_at, updated_at"
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	var customerId sql.NullString
+	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &customerId, &u.TokenVersion, &u.CreatedAt, &u.UpdatedAt)
+	u.CustomerId = customerId.String
+	return u, err
+}
+
+func (r *SqlRepository) GetUserById(userId string) (*User, error) {
+	u, err := scanUser(r.queryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no user with id: %s", userId)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", userId, err)
+	}
+
+	return &u, nil
+}
+
+func (r *SqlRepository) GetUserByEmail(email string) (*User, error) {
+	u, err := scanUser(r.queryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no user with email: %s", email)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", email, err)
+	}
+
+	return &u, nil
+}
+
+func (r *SqlRepository) AddUser(user *User) (string, error) {
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+
+	customerId := sql.NullString{String: user.CustomerId, Valid: user.CustomerId != ""}
+	id, err := r.insert("users", "EMAIL, PASSWORD_HASH, ROLE, CUSTOMER_ID, CREATED_AT, UPDATED_AT", user.Email, user.PasswordHash, user.Role, customerId, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add user to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+// UpdateUserPassword replaces the password of the user and moves it to the
+// next token version.
+func (r *SqlRepository) UpdateUserPassword(userId string, passwordHash string) error {
+	result, err := r.exec("UPDATE users SET PASSWORD_HASH = ?, token_version = token_version + 1, UPDATED_AT = ? WHERE ID = ?", passwordHash, now(), userId)
+	if err != nil {
+		return fmt.Errorf("failed to update password in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no user with id: %s", userId)
+	}
+
+	return nil
+}
+
+func (r *SqlRepository) AddPasswordReset(reset *PasswordReset) error {
+	reset.CreatedAt = now()
+
+	id, err := r.insert("password_resets", "USER_ID, TOKEN_HASH, EXPIRES_AT, CREATED_AT", reset.UserId, reset.TokenHash, reset.ExpiresAt, reset.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add password reset to the database, error: %s", err)
+	}
+	reset.ID = id
+
+	return nil
+}
+
+func (r *SqlRepository) GetPasswordReset(tokenHash string) (*PasswordReset, error) {
+	var reset PasswordReset
+	var usedAt sql.NullTime
+	err := r.queryRow("SELECT id, user_id, token_hash, expires_at, used_at, created_at FROM password_resets WHERE token_hash = ?", tokenHash).
+		Scan(&reset.ID, &reset.UserId, &reset.TokenHash, &reset.ExpiresAt, &usedAt, &reset.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no password reset with this token")
+		}
+		return nil, fmt.Errorf("searching for password reset failed with: %s", err)
+	}
+
+	if usedAt.Valid {
+		reset.UsedAt = &usedAt.Time
+	}
+
+	return &reset, nil
+}
+
+// UsePasswordReset marks the reset as used. It succeeds only once per reset,
+// so a token cannot be redeemed twice concurrently.
+func (r *SqlRepository) UsePasswordReset(resetId string) error {
+	result, err := r.exec("UPDATE password_resets SET USED_AT = ? WHERE ID = ? AND USED_AT IS NULL", now(), resetId)
+	if err != nil {
+		return fmt.Errorf("failed to use password reset in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("password reset %s is already used", resetId)
+	}
+
+	return nil
+}
+
+// apiKeyColumns are the columns of API keys in the order scanAPIKey reads them.
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.CreatedAt, &lastUsedAt, &revokedAt)
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return k, err
+}
+
+func (r *SqlRepository) GetAllAPIKeys() ([]APIKey, error) {
+	rows, err := r.query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("error while reading API keys from database: %s", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("parsing to an API key failed with: %v", err)
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *SqlRepository) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	k, err := scanAPIKey(r.queryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no API key with this hash")
+		}
+		return nil, fmt.Errorf("searching for API key failed with: %s", err)
+	}
+
+	return &k, nil
+}
+
+func (r *SqlRepository) AddAPIKey(key *APIKey) (string, error) {
+	key.CreatedAt = now()
+
+	id, err := r.insert("api_keys", "NAME, PREFIX, KEY_HASH, SCOPES, CREATED_AT", key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, ","), key.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add API key to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+// RevokeAPIKey stops the key from being accepted. Revoking a revoked key
+// keeps the time of the first revocation.
+func (r *SqlRepository) RevokeAPIKey(keyId string) error {
+	if _, err := r.exec("UPDATE api_keys SET REVOKED_AT = ? WHERE ID = ? AND REVOKED_AT IS NULL", now(), keyId); err != nil {
+		return fmt.Errorf("failed to revoke API key in the database, error: %s", err)
+	}
+
+	var id string
+	if err := r.queryRow("SELECT id FROM api_keys WHERE id = ?", keyId).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no API key with id: %s", keyId)
+		}
+		return fmt.Errorf("searching for %s failed with: %s", keyId, err)
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that the key was just used.
+func (r *SqlRepository) TouchAPIKey(keyId string) error {
+	if _, err := r.exec("UPDATE api_keys SET LAST_USED_AT = ? WHERE ID = ?", now(), keyId); err != nil {
+		return fmt.Errorf("failed to update API key in the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// GetCartById reads the cart with the products and quantities in it, in the
+// order they were put into it. Only the stored fields of the items are set.
+func (r *SqlRepository) GetCartById(cartId string) (*Cart, error) {
+	var cart Cart
+	var customerId, orderId sql.NullString
+	err := r.queryRow("SELECT id, customer_id, order_id, created_at, updated_at FROM carts WHERE id = ?", cartId).
+		Scan(&cart.ID, &customerId, &orderId, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no cart with id: %s", cartId)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", cartId, err)
+	}
+	cart.CustomerId = customerId.String
+	cart.OrderId = orderId.String
+
+	rows, err := r.query("SELECT product_id, quantity, created_at FROM cart_items WHERE cart_id = ? ORDER BY created_at, id", cartId)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading cart items from database: %s", err)
+	}
+	defer rows.Close()
+
+	cart.Items = []CartItem{}
+	for rows.Next() {
+		var item CartItem
+		if err := rows.Scan(&item.ProductId, &item.Quantity, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("parsing to a cart item failed with: %v", err)
+		}
+
+		cart.Items = append(cart.Items, item)
+	}
+
+	return &cart, rows.Err()
+}
+
+func (r *SqlRepository) AddCart(cart *Cart) (string, error) {
+	cart.CreatedAt = now()
+	cart.UpdatedAt = cart.CreatedAt
+
+	customerId := sql.NullString{String: cart.CustomerId, Valid: cart.CustomerId != ""}
+	id, err := r.insert("carts", "CUSTOMER_ID, CREATED_AT, UPDATED_AT", customerId, cart.CreatedAt, cart.UpdatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add cart to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+func (r *SqlRepository) AddCartItem(cartId string, item *CartItem) error {
+	item.CreatedAt = now()
+
+	if _, err := r.insert("cart_items", "CART_ID, PRODUCT_ID, QUANTITY, CREATED_AT", cartId, item.ProductId, item.Quantity, item.CreatedAt); err != nil {
+		return fmt.Errorf("failed to add cart item to the database, error: %s", err)
+	}
+
+	return r.touchCart(cartId, item.CreatedAt)
+}
+
+func (r *SqlRepository) UpdateCartItem(cartId string, productId string, quantity int) error {
+	result, err := r.exec("UPDATE cart_items SET QUANTITY = ? WHERE CART_ID = ? AND PRODUCT_ID = ?", quantity, cartId, productId)
+	if err != nil {
+		return fmt.Errorf("failed to update cart item in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no product %s in cart %s", productId, cartId)
+	}
+
+	return r.touchCart(cartId, now())
+}
+
+func (r *SqlRepository) DeleteCartItem(cartId string, productId string) error {
+	result, err := r.exec("DELETE FROM cart_items WHERE CART_ID = ? AND PRODUCT_ID = ?", cartId, productId)
+	if err != nil {
+		return fmt.Errorf("failed to delete cart item from the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no product %s in cart %s", productId, cartId)
+	}
+
+	return r.touchCart(cartId, now())
+}
+
+// CheckOutCart links the cart to the order it became. It succeeds only once
+// per cart, so a cart cannot be ordered twice concurrently.
+func (r *SqlRepository) CheckOutCart(cartId string, orderId string) error {
+	result, err := r.exec("UPDATE carts SET ORDER_ID = ?, UPDATED_AT = ? WHERE ID = ? AND ORDER_ID IS NULL", orderId, now(), cartId)
+	if err != nil {
+		return fmt.Errorf("failed to check out cart in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("cart %s is already checked out", cartId)
+	}
+
+	return nil
+}
+
+func (r *SqlRepository) touchCart(cartId string, at time.Time) error {
+	if _, err := r.exec("UPDATE carts SET UPDATED_AT = ? WHERE ID = ?", at, cartId); err != nil {
+		return fmt.Errorf("failed to update cart in the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// reservationColumns are the columns of reservations in the order scanReservation reads them.
+const reservationColumns = "id, cart_id, product_id, quantity, expires_at, created_at"
+
+func scanReservation(row scanner) (Reservation, error) {
+	var res Reservation
+	err := row.Scan(&res.ID, &res.CartId, &res.ProductId, &res.Quantity, &res.ExpiresAt, &res.CreatedAt)
+	return res, err
+}
+
+func (r *SqlRepository) queryReservations(query string, args ...interface{}) ([]Reservation, error) {
+	rows, err := r.query("SELECT "+reservationColumns+" FROM reservations "+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading reservations from database: %s", err)
+	}
+	defer rows.Close()
+
+	reservations := []Reservation{}
+	for rows.Next() {
+		res, err := scanReservation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("parsing to a reservation failed with: %v", err)
+		}
+
+		reservations = append(reservations, res)
+	}
+
+	return reservations, rows.Err()
+}
+
+func (r *SqlRepository) GetReservationsForCart(cartId string) ([]Reservation, error) {
+	return r.queryReservations("WHERE cart_id = ? ORDER BY created_at, id", cartId)
+}
+
+// GetExpiredReservations reads at most limit reservations that expired
+// before the time, the longest expired first.
+func (r *SqlRepository) GetExpiredReservations(before time.Time, limit int) ([]Reservation, error) {
+	return r.queryReservations("WHERE expires_at < ? ORDER BY expires_at, id LIMIT ?", before, limit)
+}
+
+func (r *SqlRepository) AddReservation(res *Reservation) error {
+	res.CreatedAt = now()
+
+	id, err := r.insert("reservations", "CART_ID, PRODUCT_ID, QUANTITY, EXPIRES_AT, CREATED_AT", res.CartId, res.ProductId, res.Quantity, res.ExpiresAt, res.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add reservation to the database, error: %s", err)
+	}
+	res.ID = id
+
+	return nil
+}
+
+// DeleteReservation removes the reservation. It succeeds only once per
+// reservation, so its stock is released only once when the expiry and a
+// checkout race.
+func (r *SqlRepository) DeleteReservation(reservationId string) error {
+	result, err := r.exec("DELETE FROM reservations WHERE ID = ?", reservationId)
+	if err != nil {
+		return fmt.Errorf("failed to delete reservation from the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no reservation with id: %s", reservationId)
+	}
+
+	return nil
+}
+
+func (r *SqlRepository) AddOrderDiscount(discount *OrderDiscount) error {
+	id, err := r.insert("order_discounts", "ORDER_ID, POSITION, SOURCE, CODE, DESCRIPTION, AMOUNT, CURRENCY", discount.OrderId, discount.Position, discount.Source, discount.Code, discount.Description, discount.Amount.Amount, discount.Amount.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to add order discount to the database, error: %s", err)
+	}
+	discount.ID = id
+
+	return nil
+}
+
+func (r *SqlRepository) DeleteOrderDiscounts(orderId string) error {
+	if _, err := r.exec("DELETE FROM order_discounts WHERE ORDER_ID = ?", orderId); err != nil {
+		return fmt.Errorf("failed to delete order discounts from the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// GetOrderPromotions returns the promotions as they were when the order was
+// placed, in the order they were applied. Only ID, Name and Rule are kept.
+func (r *SqlRepository) GetOrderPromotions(orderId string) ([]Promotion, error) {
+	rows, err := r.query("SELECT promotion_id, name, rule FROM order_promotions WHERE order_id = ? ORDER BY position", orderId)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading order promotions from database: %s", err)
+	}
+	defer rows.Close()
+
+	var promotions []Promotion
+	for rows.Next() {
+		var p Promotion
+		var rule string
+		if err = rows.Scan(&p.ID, &p.Name, &rule); err != nil {
+			return nil, fmt.Errorf("parsing to an order promotion failed with: %v", err)
+		}
+		if err = json.Unmarshal([]byte(rule), &p.Rule); err != nil {
+			return nil, fmt.Errorf("rule of promotion %s on order %s is malformed: %s", p.ID, orderId, err)
+		}
+		promotions = append(promotions, p)
+	}
+
+	return promotions, rows.Err()
+}
+
+// AddOrderPromotions keeps a copy of the promotions the order was placed with.
+func (r *SqlRepository) AddOrderPromotions(orderId string, promotions []Promotion) error {
+	for i, p := range promotions {
+		rule, err := json.Marshal(p.Rule)
+		if err != nil {
+			return fmt.Errorf("encoding promotion rule failed with: %s", err)
+		}
+
+		if _, err = r.insert("order_promotions", "ORDER_ID, POSITION, PROMOTION_ID, NAME, RULE", orderId, i, p.ID, p.Name, string(rule)); err != nil {
+			return fmt.Errorf("failed to add order promotion to the database, error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *SqlRepository) DeleteOrderPromotions(orderId string) error {
+	if _, err := r.exec("DELETE FROM order_promotions WHERE ORDER_ID = ?", orderId); err != nil {
+		return fmt.Errorf("failed to delete order promotions from the database, error: %s", err)
+	}
+
+	return nil
+}
+
+// couponColumns are the columns of coupons in the order scanCoupon reads them.
+const couponColumns = "id, code, kind, percent, amount, currency, product_id, category, valid_from, valid_until, max_uses, max_uses_per_customer, uses, created_at"
+
+func scanCoupon(row scanner) (Coupon, error) {
+	var c Coupon
+	var productId sql.NullString
+	var validFrom, validUntil sql.NullTime
+	err := row.Scan(&c.ID, &c.Code, &c.Kind, &c.Percent, &c.Amount.Amount, &c.Amount.Currency, &productId, &c.Category, &validFrom, &validUntil, &c.MaxUses, &c.MaxUsesPerCustomer, &c.Uses, &c.CreatedAt)
+	c.ProductId = productId.String
+	if validFrom.Valid {
+		c.ValidFrom = &validFrom.Time
+	}
+	if validUntil.Valid {
+		c.ValidUntil = &validUntil.Time
+	}
+	return c, err
+}
+
+func (r *SqlRepository) GetAllCoupons() ([]Coupon, error) {
+	rows, err := r.query("SELECT " + couponColumns + " FROM coupons ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("error while reading coupons from database: %s", err)
+	}
+	defer rows.Close()
+
+	coupons := []Coupon{}
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("parsing to a coupon failed with: %v", err)
+		}
+
+		coupons = append(coupons, c)
+	}
+
+	return coupons, rows.Err()
+}
+
+func (r *SqlRepository) GetCouponByCode(code string) (*Coupon, error) {
+	c, err := scanCoupon(r.queryRow("SELECT "+couponColumns+" FROM coupons WHERE code = ?", code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no coupon with code: %s", code)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", code, err)
+	}
+
+	return &c, nil
+}
+
+func (r *SqlRepository) AddCoupon(coupon *Coupon) (string, error) {
+	coupon.CreatedAt = now()
+
+	productId := sql.NullString{String: coupon.ProductId, Valid: coupon.ProductId != ""}
+	id, err := r.insert("coupons", "CODE, KIND, PERCENT, AMOUNT, CURRENCY, PRODUCT_ID, CATEGORY, VALID_FROM, VALID_UNTIL, MAX_USES, MAX_USES_PER_CUSTOMER, CREATED_AT",
+		coupon.Code, coupon.Kind, coupon.Percent, coupon.Amount.Amount, coupon.Amount.Currency, productId, coupon.Category, coupon.ValidFrom, coupon.ValidUntil, coupon.MaxUses, coupon.MaxUsesPerCustomer, coupon.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add coupon to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+// UseCoupon counts a redemption of the coupon. The check against MaxUses and
+// the increment are a single conditional UPDATE, so concurrent orders can
+// never redeem it more often than allowed.
+func (r *SqlRepository) UseCoupon(couponId string) error {
+	result, err := r.exec("UPDATE coupons SET uses = uses + 1 WHERE id = ? AND (max_uses = 0 OR uses < max_uses)", couponId)
+	if err != nil {
+		return fmt.Errorf("updating coupon uses failed with: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("coupon %s is used up", couponId)
+	}
+
+	return nil
+}
+
+// ReleaseCoupon gives back a redemption of the coupon counted by UseCoupon.
+func (r *SqlRepository) ReleaseCoupon(couponId string) error {
+	if _, err := r.exec("UPDATE coupons SET uses = uses - 1 WHERE id = ? AND uses > 0", couponId); err != nil {
+		return fmt.Errorf("updating coupon uses failed with: %s", err)
+	}
+
+	return nil
+}
+
+// GetCouponUseNumbers returns the use numbers the customer has taken of the
+// coupon, in ascending order.
+func (r *SqlRepository) GetCouponUseNumbers(couponId string, customerId string) ([]int, error) {
+	rows, err := r.query("SELECT use_number FROM coupon_redemptions WHERE coupon_id = ? AND customer_id = ? AND use_number IS NOT NULL ORDER BY use_number", couponId, customerId)
+	if err != nil {
+		return nil, fmt.Errorf("querying coupon redemptions failed with: %s", err)
+	}
+	defer rows.Close()
+
+	var numbers []int
+	for rows.Next() {
+		var n int
+		if err = rows.Scan(&n); err != nil {
+			return nil, fmt.Errorf("scanning coupon redemption failed with: %s", err)
+		}
+		numbers = append(numbers, n)
+	}
+
+	return numbers, rows.Err()
+}
+
+func (r *SqlRepository) GetCouponRedemptionForOrder(orderId string) (*CouponRedemption, error) {
+	var redemption CouponRedemption
+	var customerId sql.NullString
+	var useNumber sql.NullInt64
+	err := r.queryRow("SELECT id, coupon_id, order_id, customer_id, use_number, created_at FROM coupon_redemptions WHERE order_id = ?", orderId).
+		Scan(&redemption.ID, &redemption.CouponId, &redemption.OrderId, &customerId, &useNumber, &redemption.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no coupon redemption for order: %s", orderId)
+		}
+		return nil, fmt.Errorf("querying coupon redemption failed with: %s", err)
+	}
+	redemption.CustomerId = customerId.String
+	redemption.UseNumber = int(useNumber.Int64)
+
+	return &redemption, nil
+}
+
+// AddCouponRedemption stores the redemption. The use number of a customer is
+// unique per coupon, so of two orders racing for the same use only one is
+// stored and the other fails with "coupon … was already used".
+func (r *SqlRepository) AddCouponRedemption(redemption *CouponRedemption) error {
+	redemption.CreatedAt = now()
+
+	customerId := sql.NullString{String: redemption.CustomerId, Valid: redemption.CustomerId != ""}
+	useNumber := sql.NullInt64{Int64: int64(redemption.UseNumber), Valid: redemption.UseNumber > 0}
+	id, err := r.insert("coupon_redemptions", "COUPON_ID, ORDER_ID, CUSTOMER_ID, USE_NUMBER, CREATED_AT", redemption.CouponId, redemption.OrderId, customerId, useNumber, redemption.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("coupon %s was already used by customer %s", redemption.CouponId, redemption.CustomerId)
+		}
+		return fmt.Errorf("failed to add coupon redemption to the database, error: %s", err)
+	}
+	redemption.ID = id
+
+	return nil
+}
+
+func (r *SqlRepository) DeleteCouponRedemption(redemptionId string) error {
+	if _, err := r.exec("DELETE FROM coupon_redemptions WHERE id = ?", redemptionId); err != nil {
+		return fmt.Errorf("deleting coupon redemption failed with: %s", err)
+	}
+
+	return nil
+}
+
+// promotionColumns are the columns of promotions in the order scanPromotion reads them.
+const promotionColumns = "id, name, rule, active, valid_from, valid_until, created_at, updated_at"
+
+func scanPromotion(row scanner) (Promotion, error) {
+	var p Promotion
+	var rule string
+	var validFrom, validUntil sql.NullTime
+	if err := row.Scan(&p.ID, &p.Name, &rule, &p.Active, &validFrom, &validUntil, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return p, err
+	}
+
+	if validFrom.Valid {
+		p.ValidFrom = &validFrom.Time
+	}
+	if validUntil.Valid {
+		p.ValidUntil = &validUntil.Time
+	}
+
+	if err := json.Unmarshal([]byte(rule), &p.Rule); err != nil {
+		return p, fmt.Errorf("rule of promotion %s is malformed: %s", p.ID, err)
+	}
+
+	return p, nil
+}
+
+func (r *SqlRepository) queryPromotions(query string, args ...interface{}) ([]Promotion, error) {
+	rows, err := r.query("SELECT "+promotionColumns+" FROM promotions"+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading promotions from database: %s", err)
+	}
+	defer rows.Close()
+
+	promotions := []Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("parsing to a promotion failed with: %v", err)
+		}
+
+		promotions = append(promotions, p)
+	}
+
+	return promotions, rows.Err()
+}
+
+func (r *SqlRepository) GetAllPromotions() ([]Promotion, error) {
+	return r.queryPromotions(" ORDER BY created_at, id")
+}
+
+// GetActivePromotions reads the promotions that apply at the time, in the
+// order they were created.
+func (r *SqlRepository) GetActivePromotions(at time.Time) ([]Promotion, error) {
+	return r.queryPromotions(" WHERE active = ? AND (valid_from IS NULL OR valid_from <= ?) AND (valid_until IS NULL OR valid_until > ?) ORDER BY created_at, id", true, at, at)
+}
+
+func (r *SqlRepository) GetPromotionById(promotionId string) (*Promotion, error) {
+	p, err := scanPromotion(r.queryRow("SELECT "+promotionColumns+" FROM promotions WHERE id = ?", promotionId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no promotion with id: %s", promotionId)
+		}
+		return nil, fmt.Errorf("searching for %s failed with: %s", promotionId, err)
+	}
+
+	return &p, nil
+}
+
+func (r *SqlRepository) AddPromotion(promotion *Promotion) (string, error) {
+	promotion.CreatedAt = now()
+	promotion.UpdatedAt = promotion.CreatedAt
+
+	rule, err := json.Marshal(promotion.Rule)
+	if err != nil {
+		return "", fmt.Errorf("encoding promotion rule failed with: %s", err)
+	}
+
+	id, err := r.insert("promotions", "NAME, RULE, ACTIVE, VALID_FROM, VALID_UNTIL, CREATED_AT, UPDATED_AT", promotion.Name, string(rule), promotion.Active, promotion.ValidFrom, promotion.ValidUntil, promotion.CreatedAt, promotion.UpdatedAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to add promotion to the database, error: %s", err)
+	}
+
+	return id, nil
+}
+
+func (r *SqlRepository) UpdatePromotion(promotion *Promotion) error {
+	promotion.UpdatedAt = now()
+
+	rule, err := json.Marshal(promotion.Rule)
+	if err != nil {
+		return fmt.Errorf("encoding promotion rule failed with: %s", err)
+	}
+
+	result, err := r.exec("UPDATE promotions SET NAME = ?, RULE = ?, ACTIVE = ?, VALID_FROM = ?, VALID_UNTIL = ?, UPDATED_AT = ? WHERE ID = ?", promotion.Name, string(rule), promotion.Active, promotion.ValidFrom, promotion.ValidUntil, promotion.UpdatedAt, promotion.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update promotion in the database, error: %s", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no promotion with id: %s", promotion.ID)
 	}
 
 	return nil
@@ -0,0 +1,471 @@
+package pkg
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingQueryer counts the statements sent through it.
+type countingQueryer struct {
+	queryer
+	count int
+}
+
+func (q *countingQueryer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	q.count++
+	return q.queryer.Exec(query, args...)
+}
+
+func (q *countingQueryer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	q.count++
+	return q.queryer.Query(query, args...)
+}
+
+func (q *countingQueryer) QueryRow(query string, args ...interface{}) *sql.Row {
+	q.count++
+	return q.queryer.QueryRow(query, args...)
+}
+
+// newTestRepository returns a repository on a fresh SQLite database.
+func newTestRepository(t testing.TB) *SqlRepository {
+	t.Helper()
+
+	os.Setenv("DB_DRIVER", "sqlite")
+	os.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "shop.sqlite"))
+
+	repository, err := InitConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+// addOrders stores n orders, each with a product and a discount.
+func addOrders(t testing.TB, r *SqlRepository, n int) {
+	t.Helper()
+
+	err := r.WithinTransaction(func(repos Repositories) error {
+		productId, err := repos.Products.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: n, Price: Money{Amount: 1000, Currency: "EUR"}})
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < n; i++ {
+			orderId, err := repos.Orders.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Price: Money{Amount: 900, Currency: "EUR"}, Subtotal: Money{Amount: 1000, Currency: "EUR"}, Status: StatusAccepted})
+			if err != nil {
+				return err
+			}
+
+			line := OrderedProduct{OrderId: orderId, ProductId: productId, ProductQuantity: 1, Name: "Shirt", Category: "Men Shirts", Price: Money{Amount: 1000, Currency: "EUR"}}
+			if err = repos.Orders.AddOrderedProduct(&line); err != nil {
+				return err
+			}
+
+			discount := OrderDiscount{OrderId: orderId, Source: DiscountCoupon, Code: "TEN", Description: "10% off the order", Amount: Money{Amount: 100, Currency: "EUR"}}
+			if err = repos.Orders.AddOrderDiscount(&discount); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllOrdersQueriesDoNotGrowWithOrders(t *testing.T) {
+	queries := map[int]int{}
+	for _, n := range []int{1, 1000} {
+		r := newTestRepository(t)
+		addOrders(t, r, n)
+
+		counter := &countingQueryer{queryer: r.db}
+		r.db = counter
+
+		page, err := r.GetAllOrders(OrderQuery{Limit: n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Orders) != n {
+			t.Fatalf("expected %d orders, got %d", n, len(page.Orders))
+		}
+		for _, o := range page.Orders {
+			if len(o.Products) != 1 || len(o.Discounts) != 1 {
+				t.Fatalf("order %s was read with %d products and %d discounts", o.ID, len(o.Products), len(o.Discounts))
+			}
+		}
+
+		queries[n] = counter.count
+	}
+
+	if queries[1] != queries[1000] {
+		t.Fatalf("reading 1 order took %d queries, reading 1000 took %d", queries[1], queries[1000])
+	}
+}
+
+func BenchmarkGetAllOrders(b *testing.B) {
+	for _, n := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("%d orders", n), func(b *testing.B) {
+			r := newTestRepository(b)
+			addOrders(b, r, n)
+
+			counter := &countingQueryer{queryer: r.db}
+			r.db = counter
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.GetAllOrders(OrderQuery{Limit: n}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(counter.count)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- prices; in cents?
+UPDATE products SET name = 'a;b?' WHERE category = "c;d";
+/* a comment; with ? */ INSERT INTO products (name) VALUES ('it''s; \'quoted\';');
+# trailing; comment`
+
+	want := []string{
+		"UPDATE products SET name = 'a;b?' WHERE category = \"c;d\"",
+		"INSERT INTO products (name) VALUES ('it''s; \\'quoted\\';')",
+	}
+
+	got := splitStatements(script)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statements, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("statement %d is %q, expected %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGetAllProductsFiltersPricesInOneCurrency(t *testing.T) {
+	r := newTestRepository(t)
+
+	for _, price := range []Money{{Amount: 1500, Currency: "EUR"}, {Amount: 1500, Currency: "USD"}, {Amount: 500, Currency: "EUR"}} {
+		if _, err := r.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 1, Price: price}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atLeast := int64(1000)
+	page, err := r.GetAllProducts(ProductQuery{Limit: 10, MinPrice: &atLeast, PriceCurrency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Products) != 1 || page.Products[0].Price != (Money{Amount: 1500, Currency: "EUR"}) {
+		t.Fatalf("expected the product of 15.00 EUR, got %+v", page.Products)
+	}
+}
+
+func TestUpdateCartItemNotInCart(t *testing.T) {
+	r := newTestRepository(t)
+
+	productId, err := r.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000, Currency: "EUR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cartId, err := r.AddCart(&Cart{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.UpdateCartItem(cartId, productId, 2); err == nil || !strings.HasPrefix(err.Error(), "no product") {
+		t.Fatalf("expected the product not to be found in the cart, got %v", err)
+	}
+
+	if err = r.AddCartItem(cartId, &CartItem{ProductId: productId, Quantity: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateCartItem(cartId, productId, 2); err != nil {
+		t.Fatalf("setting the same quantity again failed: %s", err)
+	}
+}
+
+func TestMigrationsGoDownAndUpAgain(t *testing.T) {
+	r := newTestRepository(t)
+	addOrders(t, r, 3)
+
+	customerId, err := r.AddCustomer(&Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", CustomerId: customerId, Status: StatusAccepted}); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := loadMigrations(r.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].version
+
+	// Down one at a time, with orders of customers and their products in the
+	// tables.
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if err = r.MigrateDown(1); err != nil {
+			t.Fatalf("migrating %04d_%s down failed: %s", migrations[i].version, migrations[i].name, err)
+		}
+
+		want := 0
+		if i > 0 {
+			want = migrations[i-1].version
+		}
+		if version, err := r.SchemaVersion(); err != nil || version != want {
+			t.Fatalf("expected version %d after migrating %04d down, got %d (%v)", want, migrations[i].version, version, err)
+		}
+	}
+
+	if err = r.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := r.SchemaVersion(); err != nil || version != latest {
+		t.Fatalf("expected version %d after migrating up again, got %d (%v)", latest, version, err)
+	}
+
+	addOrders(t, r, 3)
+	page, err := r.GetAllOrders(OrderQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Orders) != 3 {
+		t.Fatalf("expected 3 orders in the migrated database, got %d", len(page.Orders))
+	}
+}
+
+// addProducts stores a product for each of the prices, in euro cents.
+func addProducts(t testing.TB, r *SqlRepository, prices ...int64) []string {
+	t.Helper()
+
+	var ids []string
+	for i, price := range prices {
+		id, err := r.AddProduct(&Product{Name: fmt.Sprintf("Shirt %d", i), Category: "Men Shirts", Quantity: 10, Price: Money{Amount: price, Currency: "EUR"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func TestGetAllProductsPagesThroughEveryProductOnce(t *testing.T) {
+	r := newTestRepository(t)
+	addProducts(t, r, 300, 100, 200, 100, 500)
+
+	for _, tc := range []struct {
+		sort       string
+		descending bool
+	}{
+		{"", false},
+		{"price", false},
+		{"price", true},
+		{"name", true},
+		{"quantity", false},
+	} {
+		query := ProductQuery{Limit: 2, SortBy: tc.sort, Descending: tc.descending}
+		seen := map[string]bool{}
+		var prices []int64
+		for pages := 1; ; pages++ {
+			page, err := r.GetAllProducts(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 5 {
+				t.Fatalf("sorted by %q page %d has a total of %d", tc.sort, pages, page.Total)
+			}
+			for _, p := range page.Products {
+				if seen[p.ID] {
+					t.Fatalf("sorted by %q product %s is on two pages", tc.sort, p.ID)
+				}
+				seen[p.ID] = true
+				prices = append(prices, p.Price.Amount)
+			}
+
+			if page.NextCursor == "" {
+				if pages != 3 {
+					t.Fatalf("sorted by %q the products took %d pages", tc.sort, pages)
+				}
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		if len(seen) != 5 {
+			t.Fatalf("sorted by %q %d of 5 products were listed", tc.sort, len(seen))
+		}
+		if tc.sort == "price" {
+			for i := 1; i < len(prices); i++ {
+				if tc.descending && prices[i] > prices[i-1] || !tc.descending && prices[i] < prices[i-1] {
+					t.Fatalf("sorted by price the products came as %v", prices)
+				}
+			}
+		}
+	}
+
+	if _, err := r.GetAllProducts(ProductQuery{Limit: 2, Cursor: "bm90IGEgY3Vyc29y"}); err == nil {
+		t.Fatal("a cursor that was not handed out was accepted")
+	}
+}
+
+func TestGetAllOrdersPagesThroughEveryOrderOnce(t *testing.T) {
+	r := newTestRepository(t)
+	addOrders(t, r, 5)
+
+	for _, descending := range []bool{false, true} {
+		query := OrderQuery{Limit: 2, SortBy: "created_at", Descending: descending}
+		seen := map[string]bool{}
+		for {
+			page, err := r.GetAllOrders(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 5 {
+				t.Fatalf("a page has a total of %d", page.Total)
+			}
+			for _, o := range page.Orders {
+				if seen[o.ID] {
+					t.Fatalf("order %s is on two pages", o.ID)
+				}
+				seen[o.ID] = true
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		if len(seen) != 5 {
+			t.Fatalf("%d of 5 orders were listed", len(seen))
+		}
+	}
+}
+
+func TestGetAllProductsFilters(t *testing.T) {
+	r := newTestRepository(t)
+
+	for _, p := range []Product{
+		{Name: "Blue Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1500, Currency: "EUR"}},
+		{Name: "Red Shirt", Category: "Men Shirts", Quantity: 0, Price: Money{Amount: 500, Currency: "EUR"}},
+		{Name: "Hat", Category: "Hats", Quantity: 5, Price: Money{Amount: 2500, Currency: "EUR"}},
+		{Name: "100% Cotton_Shirt", Category: "Men Shirts", Quantity: 3, Price: Money{Amount: 1000, Currency: "EUR"}},
+	} {
+		id, err := r.AddProduct(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// All hats are held for carts.
+		if p.Name == "Hat" {
+			if err = r.ReserveProduct(id, 5); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	price := func(amount int64) *int64 { return &amount }
+
+	for _, tc := range []struct {
+		name  string
+		query ProductQuery
+		want  []string
+	}{
+		{"category", ProductQuery{Category: "Men Shirts"}, []string{"Red Shirt", "100% Cotton_Shirt", "Blue Shirt"}},
+		{"min price", ProductQuery{MinPrice: price(1000), PriceCurrency: "EUR"}, []string{"100% Cotton_Shirt", "Blue Shirt", "Hat"}},
+		{"max price", ProductQuery{MaxPrice: price(1000), PriceCurrency: "EUR"}, []string{"Red Shirt", "100% Cotton_Shirt"}},
+		{"price range", ProductQuery{MinPrice: price(1000), MaxPrice: price(1500), PriceCurrency: "EUR"}, []string{"100% Cotton_Shirt", "Blue Shirt"}},
+		{"in stock", ProductQuery{InStock: true}, []string{"100% Cotton_Shirt", "Blue Shirt"}},
+		{"name in any case", ProductQuery{Name: "SHIRT"}, []string{"Red Shirt", "100% Cotton_Shirt", "Blue Shirt"}},
+		{"name with %", ProductQuery{Name: "%"}, []string{"100% Cotton_Shirt"}},
+		{"name with _", ProductQuery{Name: "n_s"}, []string{"100% Cotton_Shirt"}},
+		{"combined", ProductQuery{Category: "Men Shirts", InStock: true, MaxPrice: price(1200), PriceCurrency: "EUR"}, []string{"100% Cotton_Shirt"}},
+		{"descending", ProductQuery{Category: "Men Shirts", Descending: true}, []string{"Blue Shirt", "100% Cotton_Shirt", "Red Shirt"}},
+	} {
+		tc.query.Limit = 10
+		if tc.query.SortBy == "" {
+			tc.query.SortBy = "price"
+		}
+
+		page, err := r.GetAllProducts(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		var names []string
+		for _, p := range page.Products {
+			names = append(names, p.Name)
+		}
+		if strings.Join(names, ", ") != strings.Join(tc.want, ", ") || page.Total != len(tc.want) {
+			t.Fatalf("%s: expected %v, got %v of %d", tc.name, tc.want, names, page.Total)
+		}
+	}
+
+	page, err := r.GetAllProducts(ProductQuery{Limit: 10, SortBy: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Products[0].Name != "100% Cotton_Shirt" || page.Products[3].Name != "Red Shirt" {
+		t.Fatalf("sorted by name the products came as %s to %s", page.Products[0].Name, page.Products[3].Name)
+	}
+}
+
+func TestGetAllOrdersBetweenDates(t *testing.T) {
+	r := newTestRepository(t)
+	addOrders(t, r, 4)
+
+	page, err := r.GetAllOrders(OrderQuery{Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed := []time.Time{
+		time.Date(2021, 5, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 6, 30, 23, 59, 59, 0, time.UTC),
+		time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for i, o := range page.Orders {
+		if _, err = r.exec("UPDATE orders SET created_at = ? WHERE id = ?", placed[i], o.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	date := func(year int, month time.Month, day int, zone *time.Location) *time.Time {
+		d := time.Date(year, month, day, 0, 0, 0, 0, zone)
+		return &d
+	}
+	sofia := time.FixedZone("EEST", 3*60*60)
+
+	for _, tc := range []struct {
+		name string
+		from *time.Time
+		to   *time.Time
+		want int
+	}{
+		{"June", date(2021, 6, 1, time.UTC), date(2021, 7, 1, time.UTC), 2},
+		{"from June", date(2021, 6, 1, time.UTC), nil, 3},
+		{"before June", nil, date(2021, 6, 1, time.UTC), 1},
+		{"June in Sofia", date(2021, 6, 1, sofia), date(2021, 7, 1, sofia), 2},
+		{"July 2nd", date(2021, 7, 2, time.UTC), nil, 0},
+	} {
+		page, err := r.GetAllOrders(OrderQuery{Limit: 10, From: tc.from, To: tc.to})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Orders) != tc.want || page.Total != tc.want {
+			t.Fatalf("%s: expected %d orders, got %d of %d", tc.name, tc.want, len(page.Orders), page.Total)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
+)
+
+// Dialect names the SQL flavour spoken by the database behind a repository.
+type Dialect string
+
+const (
+	MySql    Dialect = "mysql"
+	Sqlite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// rebind rewrites the ? placeholders of query into the bind variables of the dialect.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// isUniqueViolation reports whether err is a database refusing a row that
+// would break a UNIQUE constraint, whichever dialect it came from.
+func isUniqueViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	return false
+}
+
+func (r *SqlRepository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.Query(r.dialect.rebind(query), args...)
+}
+
+func (r *SqlRepository) queryRow(query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRow(r.dialect.rebind(query), args...)
+}
+
+func (r *SqlRepository) exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.db.Exec(r.dialect.rebind(query), args...)
+}
+
+// insert adds a row with the given columns to table and returns its id.
+// Postgres generates the uuid itself and hands it back with RETURNING,
+// for MySQL and SQLite it is generated here.
+func (r *SqlRepository) insert(table string, columns string, values ...interface{}) (string, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+
+	if r.dialect == Postgres {
+		var id string
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", table, columns, placeholders)
+		if err := r.queryRow(query, values...).Scan(&id); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate uuid error: %s", err)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (ID, %s) VALUES (?,%s)", table, columns, placeholders)
+	if _, err = r.exec(query, append([]interface{}{id.String()}, values...)...); err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
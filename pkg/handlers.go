@@ -2,45 +2,84 @@ package pkg
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-rest-shop-backend/pkg/service"
 	"github.com/golang-rest-shop-backend/pkg/structs"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// @Summary Get all products from the shop
+// Handler serves the HTTP API of the shop. Access tokens are signed with secret.
+type Handler struct {
+	service *service.Service
+	secret  []byte
+}
+
+func NewHandler(s *service.Service, secret []byte) *Handler {
+	return &Handler{service: s, secret: secret}
+}
+
+// @Summary Get a page of products from the shop
 // @Tags         Products
+// @Param   limit		query   int     false  "Maximum number of products on the page, 20 by default and at most 100"
+// @Param   cursor		query   string  false  "next_cursor of the previous page"
+// @Param   category	query   string  false  "Only products of this category"
+// @Param   min_price	query   number  false  "Only products costing at least this much, e.g. 9.99"
+// @Param   max_price	query   number  false  "Only products costing at most this much, e.g. 49.99"
+// @Param   price_currency	query   string  false  "Only products priced in this currency, EUR by default when min_price or max_price is given"
+// @Param   in_stock	query   bool    false  "Only products with stock that is not reserved"
+// @Param   name		query   string  false  "Only products whose name contains this text"
+// @Param   sort		query   string  false  "price, name or quantity, prefixed with - for descending order"
+// @Param   currency	query   string  false  "Show the prices in this currency: EUR, BGN, CAD, CHF, GBP or USD"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Invalid filter, sort, limit or cursor"
 // @Failure 500 {string} string "Internal server error"
 // @Router /product [get]
-func GetAllProductHandler(c *gin.Context) {
-	currency := c.Param("currency")
+func (h *Handler) GetAllProductHandler(c *gin.Context) {
+	currency := c.Query("currency")
 
-	products, err := service.GetAllProducts(currency)
+	query, err := parseProductQuery(c)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
 
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.service.GetAllProducts(query, currency)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "invalid cursor") || strings.Contains(err.Error(), "invalid sort") || strings.Contains(err.Error(), "invalid price_currency") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, products)
+	c.JSON(http.StatusOK, page)
 }
 
 // @Summary Get a product by id from the shop
 // @Tags         Products
 // @Param   productId	path   string     true  "ID of the product"
+// @Param   currency	query   string  false  "Show the prices in this currency: EUR, BGN, CAD, CHF, GBP or USD"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
+// @Header  200 {string} ETag "Version of the product, to be sent back in If-Match on update"
 // @Failure 404 {string} string "Product with such Id not found"
 // @Router /product/{productId} [get]
-func GetProductHandler(c *gin.Context) {
-	currency := c.Param("currency")
+func (h *Handler) GetProductHandler(c *gin.Context) {
+	currency := c.Query("currency")
 	productId := c.Param("productId")
 
-	product, err := service.GetProductById(productId, currency)
+	product, err := h.service.GetProductById(productId, currency)
 	if err != nil {
 		c.String(http.StatusNotFound, err.Error())
 
@@ -48,41 +87,72 @@ func GetProductHandler(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(product.Version))
+
 	c.JSON(http.StatusOK, product)
 }
 
-// @Summary Get all orders from the shop
+// @Summary Get a page of orders from the shop
 // @Tags         Orders
+// @Param   limit	query   int     false  "Maximum number of orders on the page, 20 by default and at most 100"
+// @Param   cursor	query   string  false  "next_cursor of the previous page"
+// @Param   from	query   string  false  "Only orders placed at or after this time, RFC 3339 or a date such as 2021-06-01"
+// @Param   to		query   string  false  "Only orders placed before this time, RFC 3339 or a date such as 2021-07-01"
+// @Param   sort	query   string  false  "created_at, prefixed with - for the newest orders first"
+// @Param   currency	query   string  false  "Show the prices in this currency: EUR, BGN, CAD, CHF, GBP or USD"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Invalid limit, cursor, date range or sort"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /order [get]
-func GetAllOrdersHandler(c *gin.Context) {
-	currency := c.Param("currency")
+func (h *Handler) GetAllOrdersHandler(c *gin.Context) {
+	currency := c.Query("currency")
 
-	orders, err := service.GetAllOrders(currency)
+	query, err := parseOrderQuery(c)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
 
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	page, err := h.service.GetAllOrders(query, currency)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "invalid cursor") || strings.Contains(err.Error(), "invalid sort") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
 }
 
 // @Summary Get a order by id from the shop
 // @Tags         Orders
 // @Param   orderId		path   string     true  "ID of the order"
+// @Param   currency	query   string  false  "Show the prices in this currency: EUR, BGN, CAD, CHF, GBP or USD"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
+// @Header  200 {string} ETag "Version of the order, to be sent back in If-Match on update"
 // @Failure 404 {string} string "Order with such Id not found"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /order/{orderId} [get]
-func GetOrderHandler(c *gin.Context) {
-	currency := c.Param("currency")
+func (h *Handler) GetOrderHandler(c *gin.Context) {
+	currency := c.Query("currency")
 	orderId := c.Param("orderId")
 
-	order, err := service.GetOrderById(orderId, currency)
+	order, err := h.service.GetOrderById(orderId, currency)
+	if err == nil && !ownsCustomer(c, order.CustomerId) {
+		err = fmt.Errorf("no order with id: %s", orderId)
+	}
 	if err != nil {
 		c.String(http.StatusNotFound, err.Error())
 
@@ -90,6 +160,8 @@ func GetOrderHandler(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(order.Version))
+
 	c.JSON(http.StatusOK, order)
 }
 
@@ -99,10 +171,13 @@ func GetOrderHandler(c *gin.Context) {
 // @Param   order	body   structs.ExampleOrderRequest	true  "New order details"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Order without products or coupon cannot be redeemed on the order"
 // @Failure 404 {string} string "Request has wrong format or not enought quantity of a product"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /order [post]
-func AddOrderHandler(c *gin.Context) {
+func (h *Handler) AddOrderHandler(c *gin.Context) {
 	decoder := json.NewDecoder(c.Request.Body)
 	var order structs.Order
 	err := decoder.Decode(&order)
@@ -113,9 +188,16 @@ func AddOrderHandler(c *gin.Context) {
 		return
 	}
 
-	orderID, err := service.AddOrder(&order)
+	if claims := claimsOf(c); claims != nil && claims.Role == structs.RoleCustomer {
+		order.CustomerId = claims.CustomerId
+	}
+
+	orderID, err := h.service.AddOrder(&order)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "not enough quantity") {
+		if strings.HasPrefix(err.Error(), "not enough quantity") || strings.HasPrefix(err.Error(), "invalid quantity") ||
+			strings.HasPrefix(err.Error(), "products of an order") || strings.HasPrefix(err.Error(), "archived product") ||
+			strings.HasPrefix(err.Error(), "no customer") || strings.HasPrefix(err.Error(), "invalid coupon") ||
+			strings.HasPrefix(err.Error(), "invalid order") {
 			c.String(http.StatusBadRequest, err.Error())
 
 			c.AbortWithError(http.StatusBadRequest, err)
@@ -131,6 +213,118 @@ func AddOrderHandler(c *gin.Context) {
 	c.String(http.StatusOK, "Successful purchase: %s", orderID)
 }
 
+// @Summary Cancel an order and put its products back into stock
+// @Tags         Orders
+// @Param   orderId		path   string     true  "ID of the order"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 404 {string} string "Order with such Id not found"
+// @Failure 409 {string} string "Order can no longer be cancelled"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /order/{orderId}/cancel [post]
+func (h *Handler) CancelOrderHandler(c *gin.Context) {
+	orderId := c.Param("orderId")
+	if !h.authorizeOrder(c, orderId) {
+		return
+	}
+
+	if err := h.service.CancelOrder(orderId); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no order") {
+			status = http.StatusNotFound
+		} else if strings.HasPrefix(err.Error(), "order ") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Order %s cancelled", orderId)
+}
+
+// @Summary Move an order to another status
+// @Description Accepted -> Paid -> Packed -> Shipped -> Delivered -> Returned. Orders can be Cancelled until they are shipped.
+// @Tags         Orders
+// @Accept   application/json
+// @Param   orderId		path   string     true  "ID of the order"
+// @Param   status	body   structs.StatusChangeRequest	true  "New status of the order"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or unknown status"
+// @Failure 404 {string} string "Order with such Id not found"
+// @Failure 409 {string} string "Order cannot move to this status"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /order/{orderId}/status [patch]
+func (h *Handler) ChangeOrderStatusHandler(c *gin.Context) {
+	orderId := c.Param("orderId")
+
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.StatusChangeRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.ChangeOrderStatus(orderId, request.Status); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid status") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "no order") {
+			status = http.StatusNotFound
+		} else if strings.HasPrefix(err.Error(), "order ") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Order %s is now %s", orderId, request.Status)
+}
+
+// @Summary Get the status history of an order
+// @Tags         Orders
+// @Param   orderId		path   string     true  "ID of the order"
+// @Produce  application/json
+// @Success 200 {array} structs.OrderStatusChange
+// @Failure 404 {string} string "Order with such Id not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /order/{orderId}/status/history [get]
+func (h *Handler) GetOrderStatusHistoryHandler(c *gin.Context) {
+	orderId := c.Param("orderId")
+	if !h.authorizeOrder(c, orderId) {
+		return
+	}
+
+	history, err := h.service.GetOrderStatusHistory(orderId)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no order") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
 // @Summary Add a new product
 // @Tags         Products
 // @Accept   application/json
@@ -139,8 +333,10 @@ func AddOrderHandler(c *gin.Context) {
 // @Success 200 {string} string	"Successful request"
 // @Failure 404 {string} string "Request has wrong format"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /product [post]
-func AddProductHandler(c *gin.Context) {
+func (h *Handler) AddProductHandler(c *gin.Context) {
 	decoder := json.NewDecoder(c.Request.Body)
 	var product structs.Product
 	err := decoder.Decode(&product)
@@ -151,8 +347,15 @@ func AddProductHandler(c *gin.Context) {
 		return
 	}
 
-	productID, err := service.AddProduct(&product)
+	productID, err := h.service.AddProduct(&product)
 	if err != nil {
+		if strings.HasPrefix(err.Error(), "unsupported") {
+			c.String(http.StatusBadRequest, err.Error())
+
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
 		c.String(http.StatusInternalServerError, err.Error())
 
 		c.AbortWithError(http.StatusBadRequest, err)
@@ -163,19 +366,41 @@ func AddProductHandler(c *gin.Context) {
 }
 
 // @Summary Update an order
+// @Description Products, when given, replace the products of the order and stock is adjusted. The total is computed by the shop, requests with prices are rejected. Products can only change while the order is Accepted.
 // @Tags         Orders
 // @Accept   application/json
 // @Param   orderId		path   string     true  "ID of the order"
+// @Param   If-Match	header   string     true  "ETag of the order as last read"
 // @Param   order	body   structs.ExampleOrderRequest	true  "Updated order details"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
-// @Failure 404 {string} string "Request has wrong format"
+// @Header  200 {string} ETag "New version of the order"
+// @Failure 400 {string} string "Request has wrong format, contains prices or not enough quantity of a product"
+// @Failure 404 {string} string "Order or product with such Id not found"
+// @Failure 409 {string} string "Order or its products can no longer change"
+// @Failure 412 {string} string "Order was changed since it was read"
+// @Failure 428 {string} string "If-Match header is missing"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /order/{orderId} [put]
-func UpdateOrderHandler(c *gin.Context) {
+func (h *Handler) UpdateOrderHandler(c *gin.Context) {
+	version, err := parseIfMatch(c)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "missing") {
+			status = http.StatusPreconditionRequired
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
 	decoder := json.NewDecoder(c.Request.Body)
 	var order structs.Order
-	err := decoder.Decode(&order)
+	err = decoder.Decode(&order)
 	if err != nil {
 		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
 
@@ -184,14 +409,29 @@ func UpdateOrderHandler(c *gin.Context) {
 	}
 
 	order.ID = c.Param("orderId")
+	order.Version = version
 
-	if err = service.UpdateOrder(&order); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	if err = h.service.UpdateOrder(&order); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid order") || strings.HasPrefix(err.Error(), "invalid quantity") ||
+			strings.HasPrefix(err.Error(), "not enough quantity") || strings.HasPrefix(err.Error(), "archived product") ||
+			strings.HasPrefix(err.Error(), "products of an order") || strings.HasPrefix(err.Error(), "invalid coupon") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "no order") || strings.HasPrefix(err.Error(), "no product") {
+			status = http.StatusNotFound
+		} else if strings.HasPrefix(err.Error(), "stale version") {
+			status = http.StatusPreconditionFailed
+		} else if strings.HasPrefix(err.Error(), "order ") {
+			status = http.StatusConflict
+		}
 
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
 		return
 	}
 
+	c.Header("ETag", etag(order.Version))
 	c.String(http.StatusOK, "Order %s successfully updated", order.ID)
 }
 
@@ -199,16 +439,37 @@ func UpdateOrderHandler(c *gin.Context) {
 // @Tags         Products
 // @Accept   application/json
 // @Param   productId	path   string     true  "ID of the product"
+// @Param   If-Match	header   string     true  "ETag of the product as last read"
 // @Param   order	body   structs.ExampleProductRequest	true  "Updated product details"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
-// @Failure 404 {string} string "Request has wrong format"
+// @Header  200 {string} ETag "New version of the product"
+// @Failure 400 {string} string "Request has wrong format"
+// @Failure 404 {string} string "Product with such Id not found"
+// @Failure 409 {string} string "Quantity is below the stock reserved for carts"
+// @Failure 412 {string} string "Product was changed since it was read"
+// @Failure 428 {string} string "If-Match header is missing"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /product/{productId} [put]
-func UpdateProductHandler(c *gin.Context) {
+func (h *Handler) UpdateProductHandler(c *gin.Context) {
+	version, err := parseIfMatch(c)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "missing") {
+			status = http.StatusPreconditionRequired
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
 	decoder := json.NewDecoder(c.Request.Body)
 	var product structs.Product
-	err := decoder.Decode(&product)
+	err = decoder.Decode(&product)
 	if err != nil {
 		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
 
@@ -217,55 +478,863 @@ func UpdateProductHandler(c *gin.Context) {
 	}
 
 	product.ID = c.Param("productId")
+	product.Version = version
 
-	if err = service.UpdateProduct(&product); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	if err = h.service.UpdateProduct(&product); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "unsupported") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "no product") {
+			status = http.StatusNotFound
+		} else if strings.HasPrefix(err.Error(), "stale version") {
+			status = http.StatusPreconditionFailed
+		} else if strings.HasPrefix(err.Error(), "product ") {
+			status = http.StatusConflict
+		}
 
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
 		return
 	}
 
-	c.String(http.StatusInternalServerError, "Product %s updated succesfully!", product.ID)
+	c.Header("ETag", etag(product.Version))
+	c.String(http.StatusOK, "Product %s updated succesfully!", product.ID)
 }
 
-// @Summary Delete a product
+// @Summary Archive a product, past orders keep referring to it
 // @Tags         Products
 // @Param   productId	path   string     true  "ID of the product"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
-// @Failure 404 {string} string "Request has wrong format"
+// @Failure 404 {string} string "Product with such Id not found"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /delete/product/{productId} [delete]
-func DeleteProductHandler(c *gin.Context) {
+func (h *Handler) DeleteProductHandler(c *gin.Context) {
 	productId := c.Param("productId")
 
-	if err := service.DeleteProduct(productId); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	if err := h.service.DeleteProduct(productId); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no product") {
+			status = http.StatusNotFound
+		}
 
-		c.AbortWithError(http.StatusInternalServerError, err)
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
 		return
 	}
 
 	c.String(http.StatusOK, "Product %s deleted", productId)
 }
 
+// @Summary Get a page of archived products
+// @Tags         Admin
+// @Param   limit	query   int     false  "Maximum number of products on the page, 20 by default and at most 100"
+// @Param   cursor	query   string  false  "next_cursor of the previous page"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Invalid limit or cursor"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/product/archived [get]
+func (h *Handler) GetArchivedProductsHandler(c *gin.Context) {
+	limit, err := parseLimit(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	query := structs.ProductQuery{Limit: limit, Cursor: c.Query("cursor")}
+
+	page, err := h.service.GetArchivedProducts(query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "invalid cursor") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// @Summary Restore an archived product into the catalog
+// @Tags         Admin
+// @Param   productId	path   string     true  "ID of the product"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 404 {string} string "No archived product with such Id"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/product/{productId}/restore [post]
+func (h *Handler) RestoreProductHandler(c *gin.Context) {
+	productId := c.Param("productId")
+
+	if err := h.service.RestoreProduct(productId); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no archived product") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Product %s restored", productId)
+}
+
 // @Summary Delete an order
+// @Description Orders not yet shipped are cancelled first, giving their products back to stock.
 // @Tags         Orders
 // @Param   orderId		path   string    true  "ID of the order"
 // @Produce  application/json
 // @Success 200 {string} string	"Successful request"
-// @Failure 404 {string} string "Request has wrong format"
+// @Failure 404 {string} string "Order with such Id not found"
+// @Failure 409 {string} string "Order is shipped or delivered"
 // @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /delete/order/{orderId} [delete]
-func DeleteOrderHandler(c *gin.Context) {
+func (h *Handler) DeleteOrderHandler(c *gin.Context) {
 	orderId := c.Param("orderId")
 
-	if err := service.DeleteOrder(orderId); err != nil {
+	if err := h.service.DeleteOrder(orderId); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no order") {
+			status = http.StatusNotFound
+		} else if strings.HasPrefix(err.Error(), "order ") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Order %s deleted", orderId)
+}
+
+// @Summary Add a new customer
+// @Tags         Customers
+// @Accept   application/json
+// @Param   customer	body   structs.ExampleCustomerRequest	true  "New customer details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or misses the name or address"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /customer [post]
+func (h *Handler) AddCustomerHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var customer structs.Customer
+	if err := decoder.Decode(&customer); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	customerID, err := h.service.AddCustomer(&customer)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid customer") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Customer successfully added id: %s", customerID)
+}
+
+// @Summary Get a customer by id
+// @Tags         Customers
+// @Param   customerId	path   string     true  "ID of the customer"
+// @Produce  application/json
+// @Success 200 {object} structs.Customer
+// @Failure 404 {string} string "Customer with such Id not found"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /customer/{customerId} [get]
+func (h *Handler) GetCustomerHandler(c *gin.Context) {
+	customerId := c.Param("customerId")
+
+	customer, err := h.service.GetCustomerById(customerId)
+	if err == nil && !ownsCustomer(c, customerId) {
+		err = fmt.Errorf("no customer with id: %s", customerId)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no customer") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, customer)
+}
+
+// @Summary Update a customer, orders placed before keep their delivery details
+// @Tags         Customers
+// @Accept   application/json
+// @Param   customerId	path   string     true  "ID of the customer"
+// @Param   customer	body   structs.ExampleCustomerRequest	true  "Updated customer details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or misses the name or address"
+// @Failure 404 {string} string "Customer with such Id not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /customer/{customerId} [put]
+func (h *Handler) UpdateCustomerHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var customer structs.Customer
+	if err := decoder.Decode(&customer); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	customer.ID = c.Param("customerId")
+	if !ownsCustomer(c, customer.ID) {
+		err := fmt.Errorf("no customer with id: %s", customer.ID)
+		c.String(http.StatusNotFound, err.Error())
+
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+
+	if err := h.service.UpdateCustomer(&customer); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid customer") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "no customer") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Customer %s successfully updated", customer.ID)
+}
+
+// @Summary Get a page of the orders of a customer
+// @Tags         Customers
+// @Param   customerId	path   string     true  "ID of the customer"
+// @Param   limit	query   int     false  "Maximum number of orders on the page, 20 by default and at most 100"
+// @Param   cursor	query   string  false  "next_cursor of the previous page"
+// @Param   from	query   string  false  "Only orders placed at or after this time, RFC 3339 or a date such as 2021-06-01"
+// @Param   to		query   string  false  "Only orders placed before this time, RFC 3339 or a date such as 2021-07-01"
+// @Param   sort	query   string  false  "created_at, prefixed with - for the newest orders first"
+// @Param   currency	query   string  false  "Show the prices in this currency: EUR, BGN, CAD, CHF, GBP or USD"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Invalid limit, cursor, date range or sort"
+// @Failure 404 {string} string "Customer with such Id not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /customer/{customerId}/orders [get]
+func (h *Handler) GetCustomerOrdersHandler(c *gin.Context) {
+	currency := c.Query("currency")
+	customerId := c.Param("customerId")
+	if !ownsCustomer(c, customerId) {
+		err := fmt.Errorf("no customer with id: %s", customerId)
+		c.String(http.StatusNotFound, err.Error())
+
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+
+	query, err := parseOrderQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := h.service.GetCustomerOrders(customerId, query, currency)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no customer") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid cursor") || strings.Contains(err.Error(), "invalid sort") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// etag formats a stored version as an entity tag.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseIfMatch reads the version the client expects from the If-Match header,
+// which has to hold a single entity tag as sent by etag.
+func parseIfMatch(c *gin.Context) (int, error) {
+	value := c.GetHeader("If-Match")
+	if value == "" {
+		return 0, fmt.Errorf("missing If-Match header, send the ETag of the resource")
+	}
+
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(value, "W/"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header: %s", value)
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header: %s", value)
+	}
+
+	return version, nil
+}
+
+// parseLimit reads the optional limit query parameter, 0 when it is not given.
+func parseLimit(c *gin.Context) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+
+	return limit, nil
+}
+
+// parseOrderQuery reads the paging, date range and sort parameters of the
+// order listing.
+func parseOrderQuery(c *gin.Context) (structs.OrderQuery, error) {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return structs.OrderQuery{}, err
+	}
+
+	query := structs.OrderQuery{Limit: limit, Cursor: c.Query("cursor")}
+
+	for param, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				return structs.OrderQuery{}, fmt.Errorf("invalid %s: %s", param, value)
+			}
+		}
+		*target = &t
+	}
+
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return structs.OrderQuery{}, fmt.Errorf("invalid date range: from must be before to")
+	}
+
+	query.SortBy = strings.TrimPrefix(c.Query("sort"), "-")
+	query.Descending = strings.HasPrefix(c.Query("sort"), "-")
+
+	return query, nil
+}
+
+// parseProductQuery reads the paging, filter and sort parameters of the catalog.
+func parseProductQuery(c *gin.Context) (structs.ProductQuery, error) {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return structs.ProductQuery{}, err
+	}
+
+	query := structs.ProductQuery{
+		Limit:         limit,
+		Cursor:        c.Query("cursor"),
+		Category:      c.Query("category"),
+		Name:          c.Query("name"),
+		PriceCurrency: strings.ToUpper(c.Query("price_currency")),
+	}
+
+	// Amounts in different currencies do not compare, price bounds are in
+	// the default currency unless another one is given.
+	for param, target := range map[string]**int64{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		price, err := structs.ParseMoney(value, structs.DefaultCurrency)
+		if err != nil || price.Amount < 0 {
+			return structs.ProductQuery{}, fmt.Errorf("invalid %s: %s", param, value)
+		}
+		*target = &price.Amount
+
+		if query.PriceCurrency == "" {
+			query.PriceCurrency = structs.DefaultCurrency
+		}
+	}
+
+	if value := c.Query("in_stock"); value != "" {
+		if query.InStock, err = strconv.ParseBool(value); err != nil {
+			return structs.ProductQuery{}, fmt.Errorf("invalid in_stock: %s", value)
+		}
+	}
+
+	query.SortBy = strings.TrimPrefix(c.Query("sort"), "-")
+	query.Descending = strings.HasPrefix(c.Query("sort"), "-")
+
+	return query, nil
+}
+
+// @Summary Start an empty cart
+// @Description Carts of customers always belong to the signed in customer.
+// @Tags         Carts
+// @Accept   application/json
+// @Param   cart	body   structs.CartRequest	false  "Customer the cart is for"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or unknown customer"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart [post]
+func (h *Handler) AddCartHandler(c *gin.Context) {
+	var request structs.CartRequest
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+			c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if claims := claimsOf(c); claims != nil && claims.Role == structs.RoleCustomer {
+		request.CustomerId = claims.CustomerId
+	}
+
+	cartID, err := h.service.CreateCart(request.CustomerId)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no customer") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Cart successfully created id: %s", cartID)
+}
+
+// @Summary Get a cart with the current price and stock of its products
+// @Tags         Carts
+// @Param   cartId	path   string     true  "ID of the cart"
+// @Produce  application/json
+// @Success 200 {object} structs.Cart
+// @Failure 404 {string} string "Cart with such Id not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId} [get]
+func (h *Handler) GetCartHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	cart, err := h.service.GetCart(cartId)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "no cart") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cart)
+}
+
+// @Summary Put a product into a cart or change its quantity in it
+// @Tags         Carts
+// @Accept   application/json
+// @Param   cartId		path   string     true  "ID of the cart"
+// @Param   productId	path   string     true  "ID of the product"
+// @Param   item	body   structs.CartItemRequest	true  "Quantity of the product"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format, invalid quantity or archived product"
+// @Failure 404 {string} string "Cart or product with such Id not found"
+// @Failure 409 {string} string "Cart is already checked out"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId}/item/{productId} [put]
+func (h *Handler) SetCartItemHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+	productId := c.Param("productId")
+
+	decoder := json.NewDecoder(c.Request.Body)
+	var request structs.CartItemRequest
+	if err := decoder.Decode(&request); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	if err := h.service.SetCartItem(cartId, productId, request.Quantity); err != nil {
+		cartError(c, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Cart %s has %d of product %s", cartId, request.Quantity, productId)
+}
+
+// @Summary Take a product out of a cart
+// @Tags         Carts
+// @Param   cartId		path   string     true  "ID of the cart"
+// @Param   productId	path   string     true  "ID of the product"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 404 {string} string "Cart with such Id not found or product not in it"
+// @Failure 409 {string} string "Cart is already checked out"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId}/item/{productId} [delete]
+func (h *Handler) DeleteCartItemHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+	productId := c.Param("productId")
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	if err := h.service.RemoveCartItem(cartId, productId); err != nil {
+		cartError(c, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Product %s removed from cart %s", productId, cartId)
+}
+
+// @Summary Reserve the stock of the products in a cart
+// @Description The stock is held for 15 minutes and cannot be ordered by others meanwhile. Changing the products of the cart releases it.
+// @Tags         Carts
+// @Param   cartId		path   string     true  "ID of the cart"
+// @Produce  application/json
+// @Success 200 {object} structs.Cart
+// @Failure 400 {string} string "Empty cart, not enough quantity or archived product"
+// @Failure 404 {string} string "Cart with such Id not found"
+// @Failure 409 {string} string "Cart is already checked out"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId}/reservation [post]
+func (h *Handler) ReserveCartHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	cart, err := h.service.ReserveCart(cartId)
+	if err != nil {
+		cartError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cart)
+}
+
+// @Summary Release the stock reserved for a cart
+// @Tags         Carts
+// @Param   cartId		path   string     true  "ID of the cart"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 404 {string} string "Cart with such Id not found"
+// @Failure 409 {string} string "Cart is already checked out"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId}/reservation [delete]
+func (h *Handler) ReleaseCartHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	if err := h.service.ReleaseCart(cartId); err != nil {
+		cartError(c, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Reservation of cart %s released", cartId)
+}
+
+// @Summary Order the products of a cart
+// @Description Stock reserved for the cart is used for the order. Delivery details left out are taken from the customer of the cart.
+// @Tags         Carts
+// @Accept   application/json
+// @Param   cartId		path   string     true  "ID of the cart"
+// @Param   delivery	body   structs.CheckoutRequest	false  "Delivery details of the order"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format, empty cart or not enough quantity of a product"
+// @Failure 404 {string} string "Cart with such Id not found"
+// @Failure 409 {string} string "Cart is already checked out"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /cart/{cartId}/checkout [post]
+func (h *Handler) CheckoutHandler(c *gin.Context) {
+	cartId := c.Param("cartId")
+
+	var request structs.CheckoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+			c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if !h.authorizeCart(c, cartId) {
+		return
+	}
+
+	delivery := structs.Order{Name: request.Name, Address: request.Address, Phone: request.Phone, CouponCode: request.CouponCode}
+
+	orderID, err := h.service.Checkout(cartId, &delivery)
+	if err != nil {
+		cartError(c, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Successful purchase: %s", orderID)
+}
+
+// cartError answers with the status matching an error of a cart operation.
+func cartError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if strings.HasPrefix(err.Error(), "no cart") || strings.HasPrefix(err.Error(), "no product") {
+		status = http.StatusNotFound
+	} else if strings.HasPrefix(err.Error(), "cart ") {
+		status = http.StatusConflict
+	} else if strings.HasPrefix(err.Error(), "invalid") || strings.HasPrefix(err.Error(), "not enough quantity") ||
+		strings.HasPrefix(err.Error(), "products of an order") || strings.HasPrefix(err.Error(), "archived product") ||
+		strings.HasPrefix(err.Error(), "no customer") {
+		status = http.StatusBadRequest
+	}
+
+	c.String(status, err.Error())
+
+	c.AbortWithError(status, err)
+}
+
+// @Summary Add a discount code
+// @Description Kinds are percentage (percent off the order), fixed (amount off the order), free_item (one item of product_id for free) and category (percent off the products of category). Limits of 0 are no limit.
+// @Tags         Admin
+// @Accept   application/json
+// @Param   coupon	body   structs.ExampleCouponRequest	true  "New coupon details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or invalid coupon"
+// @Failure 409 {string} string "Coupon with such code already exists"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/coupon [post]
+func (h *Handler) AddCouponHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var coupon structs.Coupon
+	if err := decoder.Decode(&coupon); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	couponID, err := h.service.AddCoupon(&coupon)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid coupon") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "coupon with code") {
+			status = http.StatusConflict
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Coupon successfully added id: %s", couponID)
+}
+
+// @Summary Get all discount codes with how often they were redeemed
+// @Tags         Admin
+// @Produce  application/json
+// @Success 200 {array} structs.Coupon
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/coupon [get]
+func (h *Handler) GetAllCouponsHandler(c *gin.Context) {
+	coupons, err := h.service.GetAllCoupons()
+	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	c.String(http.StatusInternalServerError, "order %s deleted", orderId)
+	c.JSON(http.StatusOK, coupons)
+}
+
+// @Summary Add an automatic promotion
+// @Description Kinds are buy_x_get_y (in every group of buy matching items the free cheapest ones are free) and volume_tiers (percent of the highest tier reached by the quantity of matching items off them). Items match on product_id and category when given. Active promotions apply to every order placed while they are valid.
+// @Tags         Admin
+// @Accept   application/json
+// @Param   promotion	body   structs.ExamplePromotionRequest	true  "New promotion details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or invalid promotion"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/promotion [post]
+func (h *Handler) AddPromotionHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var promotion structs.Promotion
+	if err := decoder.Decode(&promotion); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	promotionID, err := h.service.AddPromotion(&promotion)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid promotion") {
+			status = http.StatusBadRequest
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Promotion successfully added id: %s", promotionID)
+}
+
+// @Summary Get all promotions
+// @Tags         Admin
+// @Produce  application/json
+// @Success 200 {array} structs.Promotion
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/promotion [get]
+func (h *Handler) GetAllPromotionsHandler(c *gin.Context) {
+	promotions, err := h.service.GetAllPromotions()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, promotions)
+}
+
+// @Summary Update a promotion
+// @Description Changes apply to orders placed from then on, set active to false to end the promotion.
+// @Tags         Admin
+// @Accept   application/json
+// @Param   promotionId	path   string	true  "Promotion ID"
+// @Param   promotion	body   structs.ExamplePromotionRequest	true  "Promotion details"
+// @Produce  application/json
+// @Success 200 {string} string	"Successful request"
+// @Failure 400 {string} string "Request has wrong format or invalid promotion"
+// @Failure 404 {string} string "Promotion not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/promotion/{promotionId} [put]
+func (h *Handler) UpdatePromotionHandler(c *gin.Context) {
+	decoder := json.NewDecoder(c.Request.Body)
+	var promotion structs.Promotion
+	if err := decoder.Decode(&promotion); err != nil {
+		c.String(http.StatusBadRequest, "request body has wrong format: %s\n", err)
+
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	promotion.ID = c.Param("promotionId")
+
+	if err := h.service.UpdatePromotion(&promotion); err != nil {
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(err.Error(), "invalid promotion") {
+			status = http.StatusBadRequest
+		} else if strings.HasPrefix(err.Error(), "no promotion") {
+			status = http.StatusNotFound
+		}
+
+		c.String(status, err.Error())
+
+		c.AbortWithError(status, err)
+		return
+	}
+
+	c.String(http.StatusOK, "Promotion %s updated succesfully!", promotion.ID)
 }
@@ -0,0 +1,970 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-rest-shop-backend/pkg/database"
+	"github.com/golang-rest-shop-backend/pkg/service"
+	"github.com/golang-rest-shop-backend/pkg/structs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHandler returns a handler on a fresh SQLite database and a router
+// that serves its endpoints to an admin.
+func newTestHandler(t *testing.T) (*Handler, *service.Service, *database.SqlRepository, *gin.Engine) {
+	t.Helper()
+
+	os.Setenv("DB_DRIVER", "sqlite")
+	os.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "shop.sqlite"))
+
+	repository, err := database.InitConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := service.NewService(repository.Repositories(), repository, service.LogNotifier{})
+	h := NewHandler(s, []byte("secret"))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(claimsKey, &Claims{Role: structs.RoleAdmin})
+	})
+
+	return h, s, repository, r
+}
+
+func serve(r *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	return serveRequest(r, httptest.NewRequest(method, path, strings.NewReader(body)))
+}
+
+func serveRequest(r *gin.Engine, request *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestCheckoutRedeemsCoupon(t *testing.T) {
+	h, s, repository, r := newTestHandler(t)
+	r.POST("/cart/:cartId/checkout", h.CheckoutHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerId, err := s.AddCustomer(&structs.Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	couponId, err := s.AddCoupon(&structs.Coupon{Code: "TEN", Kind: structs.CouponPercentage, Percent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cartId, err := s.CreateCart(customerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetCartItem(cartId, productId, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(r, http.MethodPost, "/cart/"+cartId+"/checkout", `{"coupon_code": "ten"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("checkout answered %d: %s", w.Code, w.Body)
+	}
+
+	cart, err := s.GetCart(cartId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := s.GetOrderById(cart.OrderId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Discounts) != 1 || order.Discounts[0].Source != structs.DiscountCoupon || order.Discounts[0].Amount.Amount != 400 {
+		t.Fatalf("expected a coupon discount of 400, got %+v", order.Discounts)
+	}
+	if order.Price.Amount != 3600 {
+		t.Fatalf("expected a price of 3600, got %d", order.Price.Amount)
+	}
+
+	redemption, err := repository.GetCouponRedemptionForOrder(order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redemption.CouponId != couponId || redemption.CustomerId != customerId {
+		t.Fatalf("expected a redemption of %s by %s, got %+v", couponId, customerId, redemption)
+	}
+}
+
+func TestDeleteOrder(t *testing.T) {
+	h, s, repository, r := newTestHandler(t)
+	r.DELETE("/delete/order/:orderId", h.DeleteOrderHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(statuses ...string) string {
+		id, err := s.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 2}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range statuses {
+			if err = s.ChangeOrderStatus(id, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+
+	accepted := order()
+	packed := order(structs.StatusPaid, structs.StatusPacked)
+	shipped := order(structs.StatusPaid, structs.StatusPacked, structs.StatusShipped)
+
+	// Orders stored before orders needed products have no lines.
+	empty, err := repository.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Status: structs.StatusAccepted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		orderId string
+		status  int
+	}{
+		{accepted, http.StatusOK},
+		{packed, http.StatusOK},
+		{shipped, http.StatusConflict},
+		{empty, http.StatusOK},
+		{accepted, http.StatusNotFound},
+	} {
+		if w := serve(r, http.MethodDelete, "/delete/order/"+tc.orderId, ""); w.Code != tc.status {
+			t.Fatalf("deleting %s answered %d, expected %d: %s", tc.orderId, w.Code, tc.status, w.Body)
+		}
+	}
+
+	// Only the shipped order keeps its products out of stock.
+	product, err := s.GetProductById(productId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Quantity != 8 {
+		t.Fatalf("expected 8 in stock, got %d", product.Quantity)
+	}
+}
+
+func TestPasswordChangeRevokesTokens(t *testing.T) {
+	h, s, _, _ := newTestHandler(t)
+	r := gin.New()
+	r.GET("/account", h.Authenticate, func(c *gin.Context) { c.String(http.StatusOK, claimsOf(c).Subject) })
+
+	userId, err := s.AddUser(&structs.User{Email: "admin@shop.com", Role: structs.RoleAdmin}, "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(token *structs.Token) int {
+		request := httptest.NewRequest(http.MethodGet, "/account", nil)
+		request.Header.Set("Authorization", "Bearer "+token.Token)
+		return serveRequest(r, request).Code
+	}
+	signIn := func(password string) *structs.Token {
+		user, err := s.Login("admin@shop.com", password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := h.issueToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	old := signIn("password1")
+	if code := get(old); code != http.StatusOK {
+		t.Fatalf("a fresh token answered %d", code)
+	}
+
+	if err = s.ChangePassword(userId, "password1", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(old); code != http.StatusUnauthorized {
+		t.Fatalf("a token issued before the password change answered %d", code)
+	}
+	if code := get(signIn("password2")); code != http.StatusOK {
+		t.Fatalf("a token issued after the password change answered %d", code)
+	}
+}
+
+func TestAddOrderWithoutProducts(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.POST("/order", h.AddOrderHandler)
+
+	for _, body := range []string{`{"name": "Ivan", "address": "Sofia", "phone": "0888", "products": []}`, `{"name": "Ivan", "address": "Sofia", "phone": "0888"}`} {
+		if w := serve(r, http.MethodPost, "/order", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("an order without products answered %d: %s", w.Code, w.Body)
+		}
+	}
+
+	page, err := s.GetAllOrders(structs.OrderQuery{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 0 {
+		t.Fatalf("expected no orders to be stored, got %d", page.Total)
+	}
+}
+
+func TestUpdateProductKeepsReservedStock(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.PUT("/product/:productId", h.UpdateProductHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cartId, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetCartItem(cartId, productId, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ReserveCart(cartId); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(quantity int) *httptest.ResponseRecorder {
+		product, err := s.GetProductById(productId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := httptest.NewRequest(http.MethodPut, "/product/"+productId, strings.NewReader(fmt.Sprintf(`{"name": "Shirt", "category": "Men Shirts", "quantity": %d, "price": {"amount": 2000}}`, quantity)))
+		request.Header.Set("If-Match", etag(product.Version))
+		return serveRequest(r, request)
+	}
+
+	if w := update(2); w.Code != http.StatusConflict {
+		t.Fatalf("setting the quantity below the reserved stock answered %d: %s", w.Code, w.Body)
+	}
+	if w := update(3); w.Code != http.StatusOK {
+		t.Fatalf("setting the quantity to the reserved stock answered %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAPIKeyAccess(t *testing.T) {
+	h, s, _, _ := newTestHandler(t)
+	r := gin.New()
+	r.Use(h.Authenticate)
+	r.GET("/order", RequireAccess(structs.RoleAdmin, structs.ScopeOrderRead, structs.ScopeAdmin), h.GetAllOrdersHandler)
+
+	get := func(key *structs.IssuedAPIKey) int {
+		request := httptest.NewRequest(http.MethodGet, "/order", nil)
+		request.Header.Set(apiKeyHeader, key.Key)
+		return serveRequest(r, request).Code
+	}
+	issue := func(scopes ...string) *structs.IssuedAPIKey {
+		key, err := s.IssueAPIKey("warehouse", scopes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	reader := issue(structs.ScopeOrderRead)
+	if code := get(reader); code != http.StatusOK {
+		t.Fatalf("a key with the orders:read scope answered %d", code)
+	}
+	if code := get(issue(structs.ScopeCatalogWrite)); code != http.StatusForbidden {
+		t.Fatalf("a key without the orders:read scope answered %d", code)
+	}
+	if code := get(&structs.IssuedAPIKey{Key: "unknown"}); code != http.StatusUnauthorized {
+		t.Fatalf("an unknown key answered %d", code)
+	}
+
+	if err := s.RevokeAPIKey(reader.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(reader); code != http.StatusUnauthorized {
+		t.Fatalf("a revoked key answered %d", code)
+	}
+}
+
+func TestGetAllProductHandlerPages(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.GET("/product", h.GetAllProductHandler)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(query string) structs.ProductPage {
+		w := serve(r, http.MethodGet, "/product?"+query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("listing products with %s answered %d: %s", query, w.Code, w.Body)
+		}
+
+		var page structs.ProductPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	first := get("limit=2&sort=-price")
+	if len(first.Products) != 2 || first.Total != 3 || first.NextCursor == "" {
+		t.Fatalf("expected 2 of 3 products and a cursor, got %d of %d and %q", len(first.Products), first.Total, first.NextCursor)
+	}
+	second := get("limit=2&sort=-price&cursor=" + first.NextCursor)
+	if len(second.Products) != 1 || second.Total != 3 || second.NextCursor != "" {
+		t.Fatalf("expected the last product without a cursor, got %d of %d and %q", len(second.Products), second.Total, second.NextCursor)
+	}
+
+	for _, query := range []string{"limit=0", "limit=ten", "cursor=nonsense", "sort=-name&cursor=" + first.NextCursor, "sort=color"} {
+		if w := serve(r, http.MethodGet, "/product?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("listing products with %s answered %d: %s", query, w.Code, w.Body)
+		}
+	}
+}
+
+func TestGetAllProductHandlerFilters(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.GET("/product", h.GetAllProductHandler)
+
+	for _, p := range []structs.Product{
+		{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 1999}},
+		{Name: "Hat", Category: "Hats", Quantity: 0, Price: structs.Money{Amount: 999}},
+	} {
+		if _, err := s.AddProduct(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serve(r, http.MethodGet, "/product?min_price=10.50&max_price=19.99&in_stock=true&category=Men%20Shirts&name=shi&sort=-price", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("filtering products answered %d: %s", w.Code, w.Body)
+	}
+	var page structs.ProductPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Products) != 1 || page.Products[0].Name != "Shirt" {
+		t.Fatalf("expected the shirt, got %+v", page.Products)
+	}
+
+	for _, query := range []string{"min_price=ten", "min_price=-1", "max_price=1.999", "in_stock=maybe", "price_currency=XYZ&min_price=1"} {
+		if w := serve(r, http.MethodGet, "/product?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("listing products with %s answered %d: %s", query, w.Code, w.Body)
+		}
+	}
+}
+
+func TestArchiveAndRestoreProduct(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.GET("/product", h.GetAllProductHandler)
+	r.GET("/product/:productId", h.GetProductHandler)
+	r.DELETE("/delete/product/:productId", h.DeleteProductHandler)
+	r.GET("/admin/product/archived", h.GetArchivedProductsHandler)
+	r.POST("/admin/product/:productId/restore", h.RestoreProductHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderId, err := s.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := func(path string) int {
+		w := serve(r, http.MethodGet, path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("listing %s answered %d: %s", path, w.Code, w.Body)
+		}
+
+		var page structs.ProductPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Total
+	}
+
+	for _, step := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodDelete, "/delete/product/" + productId, http.StatusOK},
+		{http.MethodGet, "/product/" + productId, http.StatusNotFound},
+		{http.MethodDelete, "/delete/product/" + productId, http.StatusNotFound},
+	} {
+		if w := serve(r, step.method, step.path, ""); w.Code != step.status {
+			t.Fatalf("%s %s answered %d, expected %d: %s", step.method, step.path, w.Code, step.status, w.Body)
+		}
+	}
+
+	if listed("/product") != 0 || listed("/admin/product/archived") != 1 {
+		t.Fatal("the archived product was not moved out of the catalog")
+	}
+	if _, err = s.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 1}}}); err == nil || !strings.HasPrefix(err.Error(), "archived product") {
+		t.Fatalf("expected the archived product not to be ordered, got %v", err)
+	}
+
+	// Past orders keep the product.
+	order, err := s.GetOrderById(orderId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Products) != 1 || order.Products[0].Name != "Shirt" {
+		t.Fatalf("the order lost its archived product: %+v", order.Products)
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusNotFound} {
+		if w := serve(r, http.MethodPost, "/admin/product/"+productId+"/restore", ""); w.Code != status {
+			t.Fatalf("restoring the product answered %d, expected %d: %s", w.Code, status, w.Body)
+		}
+	}
+	if w := serve(r, http.MethodGet, "/product/"+productId, ""); w.Code != http.StatusOK {
+		t.Fatalf("the restored product answered %d", w.Code)
+	}
+	if listed("/product") != 1 || listed("/admin/product/archived") != 0 {
+		t.Fatal("the restored product was not moved back into the catalog")
+	}
+}
+
+func TestUpdatesNeedTheCurrentETag(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.GET("/product/:productId", h.GetProductHandler)
+	r.PUT("/product/:productId", h.UpdateProductHandler)
+	r.GET("/order/:orderId", h.GetOrderHandler)
+	r.PUT("/order/:orderId", h.UpdateOrderHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderId, err := s.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, resource := range []struct {
+		path string
+		body string
+	}{
+		{"/product/" + productId, `{"name": "Shirt", "category": "Men Shirts", "quantity": 8, "price": {"amount": 2000}}`},
+		{"/order/" + orderId, `{"name": "Petar", "address": "Plovdiv", "phone": "0999"}`},
+	} {
+		read := serve(r, http.MethodGet, resource.path, "")
+		current := read.Header().Get("ETag")
+		if read.Code != http.StatusOK || current == "" {
+			t.Fatalf("reading %s answered %d with ETag %q", resource.path, read.Code, current)
+		}
+
+		put := func(ifMatch string) *httptest.ResponseRecorder {
+			request := httptest.NewRequest(http.MethodPut, resource.path, strings.NewReader(resource.body))
+			if ifMatch != "" {
+				request.Header.Set("If-Match", ifMatch)
+			}
+			return serveRequest(r, request)
+		}
+
+		for _, tc := range []struct {
+			ifMatch string
+			status  int
+		}{
+			{"", http.StatusPreconditionRequired},
+			{"version one", http.StatusBadRequest},
+			{`"99"`, http.StatusPreconditionFailed},
+			{current, http.StatusOK},
+			{current, http.StatusPreconditionFailed},
+		} {
+			w := put(tc.ifMatch)
+			if w.Code != tc.status {
+				t.Fatalf("updating %s with If-Match %q answered %d, expected %d: %s", resource.path, tc.ifMatch, w.Code, tc.status, w.Body)
+			}
+			if w.Code == http.StatusOK && (w.Header().Get("ETag") == "" || w.Header().Get("ETag") == current) {
+				t.Fatalf("updating %s answered the ETag %q", resource.path, w.Header().Get("ETag"))
+			}
+		}
+	}
+}
+
+func TestGetAllOrdersHandlerDateRange(t *testing.T) {
+	h, _, _, r := newTestHandler(t)
+	r.GET("/order", h.GetAllOrdersHandler)
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"from=2021-06-01&to=2021-07-01", http.StatusOK},
+		{"from=2021-06-01T00:00:00%2B03:00", http.StatusOK},
+		{"to=2021-07-01", http.StatusOK},
+		{"from=June", http.StatusBadRequest},
+		{"from=2021-07-01&to=2021-06-01", http.StatusBadRequest},
+		{"from=2021-06-01&to=2021-06-01", http.StatusBadRequest},
+	} {
+		if w := serve(r, http.MethodGet, "/order?"+tc.query, ""); w.Code != tc.status {
+			t.Fatalf("listing orders with %s answered %d, expected %d: %s", tc.query, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestChangeOrderStatusHandler(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.PATCH("/order/:orderId/status", h.ChangeOrderStatusHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderId, err := s.AddOrder(&structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		orderId string
+		body    string
+		status  int
+	}{
+		{orderId, `{"status": "Shipped"}`, http.StatusConflict},
+		{orderId, `{"status": "Lost"}`, http.StatusBadRequest},
+		{orderId, `status`, http.StatusBadRequest},
+		{"no-such-order", `{"status": "Paid"}`, http.StatusNotFound},
+		{orderId, `{"status": "Paid"}`, http.StatusOK},
+		{orderId, `{"status": "Paid"}`, http.StatusConflict},
+	} {
+		if w := serve(r, http.MethodPatch, "/order/"+tc.orderId+"/status", tc.body); w.Code != tc.status {
+			t.Fatalf("changing the status of %s with %s answered %d, expected %d: %s", tc.orderId, tc.body, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestCustomerHandlers(t *testing.T) {
+	h, _, _, r := newTestHandler(t)
+	r.POST("/customer", h.AddCustomerHandler)
+	r.GET("/customer/:customerId", h.GetCustomerHandler)
+	r.PUT("/customer/:customerId", h.UpdateCustomerHandler)
+	r.GET("/customer/:customerId/orders", h.GetCustomerOrdersHandler)
+
+	w := serve(r, http.MethodPost, "/customer", `{"name": "Ivan", "address": "Sofia", "phone": "0888"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding a customer answered %d: %s", w.Code, w.Body)
+	}
+	customerId := strings.TrimPrefix(w.Body.String(), "Customer successfully added id: ")
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/customer", `{"name": "Nobody"}`, http.StatusBadRequest},
+		{http.MethodGet, "/customer/" + customerId, "", http.StatusOK},
+		{http.MethodPut, "/customer/" + customerId, `{"name": "Ivan", "address": "Plovdiv", "phone": "0888"}`, http.StatusOK},
+		{http.MethodPut, "/customer/" + customerId, `{"name": "Ivan"}`, http.StatusBadRequest},
+		{http.MethodGet, "/customer/" + customerId + "/orders", "", http.StatusOK},
+		{http.MethodGet, "/customer/no-such-customer", "", http.StatusNotFound},
+		{http.MethodPut, "/customer/no-such-customer", `{"name": "Ivan", "address": "Plovdiv"}`, http.StatusNotFound},
+		{http.MethodGet, "/customer/no-such-customer/orders", "", http.StatusNotFound},
+	} {
+		if w := serve(r, tc.method, tc.path, tc.body); w.Code != tc.status {
+			t.Fatalf("%s %s answered %d, expected %d: %s", tc.method, tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestAuthenticationAndAccess(t *testing.T) {
+	h, s, _, _ := newTestHandler(t)
+	r := gin.New()
+	r.POST("/login", h.LoginHandler)
+	authorized := r.Group("/", h.Authenticate)
+	users := authorized.Group("/", RequireAccess(structs.RoleAdmin, structs.RoleStaff, structs.RoleCustomer))
+	users.GET("/customer/:customerId", h.GetCustomerHandler)
+	users.GET("/order/:orderId", h.GetOrderHandler)
+	users.POST("/order", h.AddOrderHandler)
+	admin := authorized.Group("/", RequireAccess(structs.RoleAdmin))
+	admin.GET("/order", h.GetAllOrdersHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivan, err := s.AddCustomer(&structs.Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maria, err := s.AddCustomer(&structs.Customer{Name: "Maria", Address: "Varna", Phone: "0877"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mariasOrder, err := s.AddOrder(&structs.Order{CustomerId: maria, Products: []structs.Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddUser(&structs.User{Email: "ivan@shop.com", Role: structs.RoleCustomer, CustomerId: ivan}, "password1"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(r, http.MethodPost, "/login", `{"email": "ivan@shop.com", "password": "password1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signing in answered %d: %s", w.Code, w.Body)
+	}
+	var token structs.Token
+	if err = json.Unmarshal(w.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"email": "ivan@shop.com", "password": "password2"}`, `{"email": "nobody@shop.com", "password": "password1"}`} {
+		if w := serve(r, http.MethodPost, "/login", body); w.Code != http.StatusUnauthorized {
+			t.Fatalf("signing in with %s answered %d", body, w.Code)
+		}
+	}
+
+	user, err := s.Login("ivan@shop.com", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tokens of the user made up to be an admin.
+	forge := func(method jwt.SigningMethod, key interface{}, expiresAt time.Time) string {
+		claims := Claims{Role: structs.RoleAdmin, StandardClaims: jwt.StandardClaims{Subject: user.ID, ExpiresAt: expiresAt.Unix()}}
+		signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "/customer/" + ivan, "", http.StatusUnauthorized},
+		{http.MethodGet, "/customer/" + ivan, "nonsense", http.StatusUnauthorized},
+		{http.MethodGet, "/customer/" + ivan, forge(jwt.SigningMethodHS256, []byte("other"), time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{http.MethodGet, "/customer/" + ivan, forge(jwt.SigningMethodHS256, []byte("secret"), time.Now().Add(-time.Hour)), http.StatusUnauthorized},
+		{http.MethodGet, "/customer/" + ivan, forge(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{http.MethodGet, "/customer/" + ivan, token.Token, http.StatusOK},
+		{http.MethodGet, "/customer/" + maria, token.Token, http.StatusNotFound},
+		{http.MethodGet, "/order/" + mariasOrder, token.Token, http.StatusNotFound},
+		{http.MethodGet, "/order", token.Token, http.StatusForbidden},
+	} {
+		request := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			request.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if w := serveRequest(r, request); w.Code != tc.status {
+			t.Fatalf("%s %s answered %d, expected %d: %s", tc.method, tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+
+	// Orders of customers are theirs, whatever customer the request names.
+	request := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(fmt.Sprintf(`{"customer_id": %q, "products": [{"id": %q, "quantity": 1}]}`, maria, productId)))
+	request.Header.Set("Authorization", "Bearer "+token.Token)
+	w = serveRequest(r, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("placing an order answered %d: %s", w.Code, w.Body)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/order/"+strings.TrimPrefix(w.Body.String(), "Successful purchase: "), nil)
+	request.Header.Set("Authorization", "Bearer "+token.Token)
+	w = serveRequest(r, request)
+	var order structs.Order
+	if err = json.Unmarshal(w.Body.Bytes(), &order); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("reading the own order answered %d: %s", w.Code, w.Body)
+	}
+	if order.CustomerId != ivan || order.Address != "Sofia" {
+		t.Fatalf("expected the order to be Ivan's, got %+v", order)
+	}
+}
+
+func TestCartHandlers(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.POST("/cart", h.AddCartHandler)
+	r.GET("/cart/:cartId", h.GetCartHandler)
+	r.PUT("/cart/:cartId/item/:productId", h.SetCartItemHandler)
+	r.DELETE("/cart/:cartId/item/:productId", h.DeleteCartItemHandler)
+	r.POST("/cart/:cartId/checkout", h.CheckoutHandler)
+
+	product := structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: structs.Money{Amount: 2000}}
+	productId, err := s.AddProduct(&product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hatId, err := s.AddProduct(&structs.Product{Name: "Hat", Category: "Hats", Quantity: 10, Price: structs.Money{Amount: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(r, http.MethodPost, "/cart", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating a cart answered %d: %s", w.Code, w.Body)
+	}
+	cart := "/cart/" + strings.TrimPrefix(w.Body.String(), "Cart successfully created id: ")
+
+	view := func() structs.Cart {
+		w := serve(r, http.MethodGet, cart, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("viewing the cart answered %d: %s", w.Code, w.Body)
+		}
+
+		var c structs.Cart
+		if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, cart + "/item/" + productId, `{"quantity": 3}`, http.StatusOK},
+		{http.MethodPut, cart + "/item/" + productId, `{"quantity": 2}`, http.StatusOK},
+		{http.MethodPut, cart + "/item/" + hatId, `{"quantity": 1}`, http.StatusOK},
+		{http.MethodDelete, cart + "/item/" + hatId, "", http.StatusOK},
+		{http.MethodDelete, cart + "/item/" + hatId, "", http.StatusNotFound},
+		{http.MethodPut, cart + "/item/" + productId, `{"quantity": 0}`, http.StatusBadRequest},
+		{http.MethodPut, cart + "/item/no-such-product", `{"quantity": 1}`, http.StatusNotFound},
+		{http.MethodGet, "/cart/no-such-cart", "", http.StatusNotFound},
+	} {
+		if w := serve(r, tc.method, tc.path, tc.body); w.Code != tc.status {
+			t.Fatalf("%s %s answered %d, expected %d: %s", tc.method, tc.path, w.Code, tc.status, w.Body)
+		}
+	}
+
+	viewed := view()
+	if len(viewed.Items) != 1 || viewed.Items[0].Quantity != 2 || viewed.Items[0].Available != 10 || !viewed.Items[0].InStock || viewed.Total.Amount != 4000 {
+		t.Fatalf("expected 2 shirts for 40.00, got %+v", viewed)
+	}
+
+	// The cart shows the price of the catalog, not the one the product had
+	// when it was put in.
+	stored, err := s.GetProductById(productId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Price = structs.Money{Amount: 2500}
+	if err = s.UpdateProduct(stored); err != nil {
+		t.Fatal(err)
+	}
+	if viewed = view(); viewed.Items[0].Price.Amount != 2500 || viewed.Total.Amount != 5000 {
+		t.Fatalf("expected the cart at the new price of 25.00, got %+v", viewed)
+	}
+
+	w = serve(r, http.MethodPost, cart+"/checkout", `{"name": "Ivan", "address": "Sofia", "phone": "0888"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("checking out answered %d: %s", w.Code, w.Body)
+	}
+	order, err := s.GetOrderById(strings.TrimPrefix(w.Body.String(), "Successful purchase: "), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Price.Amount != 5000 || len(order.Products) != 1 || order.Products[0].Quantity != 2 || order.Address != "Sofia" {
+		t.Fatalf("expected an order of 2 shirts for 50.00 to Sofia, got %+v", order)
+	}
+	if stored, err = s.GetProductById(productId, ""); err != nil || stored.Quantity != 8 {
+		t.Fatalf("expected 8 shirts left, got %+v (%v)", stored, err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, cart + "/checkout", `{"name": "Ivan", "address": "Sofia", "phone": "0888"}`},
+		{http.MethodPut, cart + "/item/" + hatId, `{"quantity": 1}`},
+		{http.MethodDelete, cart + "/item/" + productId, ""},
+	} {
+		if w := serve(r, tc.method, tc.path, tc.body); w.Code != http.StatusConflict {
+			t.Fatalf("%s %s of a checked out cart answered %d: %s", tc.method, tc.path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestReserveCartHandlers(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.POST("/cart/:cartId/reservation", h.ReserveCartHandler)
+	r.DELETE("/cart/:cartId/reservation", h.ReleaseCartHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 5, Price: structs.Money{Amount: 2000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := func(expected int) {
+		t.Helper()
+
+		product, err := s.GetProductById(productId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if product.Reserved != expected {
+			t.Fatalf("expected %d reserved, got %d", expected, product.Reserved)
+		}
+	}
+
+	cartId, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodPost, "/cart/"+cartId+"/reservation", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("reserving an empty cart answered %d: %s", w.Code, w.Body)
+	}
+
+	if err = s.SetCartItem(cartId, productId, 3); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(r, http.MethodPost, "/cart/"+cartId+"/reservation", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reserving the cart answered %d: %s", w.Code, w.Body)
+	}
+	var cart structs.Cart
+	if err = json.Unmarshal(w.Body.Bytes(), &cart); err != nil {
+		t.Fatal(err)
+	}
+	if cart.ReservedUntil == nil || cart.ReservedUntil.Before(time.Now()) {
+		t.Fatalf("expected the cart to be reserved for a while, got %+v", cart)
+	}
+	reserved(3)
+
+	if w = serve(r, http.MethodDelete, "/cart/"+cartId+"/reservation", ""); w.Code != http.StatusOK {
+		t.Fatalf("releasing the cart answered %d: %s", w.Code, w.Body)
+	}
+	reserved(0)
+
+	if err = s.SetCartItem(cartId, productId, 5); err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetCartItem(other, productId, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w = serve(r, http.MethodPost, "/cart/"+cartId+"/reservation", ""); w.Code != http.StatusOK {
+		t.Fatalf("reserving the whole stock answered %d: %s", w.Code, w.Body)
+	}
+	if w = serve(r, http.MethodPost, "/cart/"+other+"/reservation", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("reserving stock held by another cart answered %d: %s", w.Code, w.Body)
+	}
+
+	if _, err = s.Checkout(cartId, &structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		if w = serve(r, method, "/cart/"+cartId+"/reservation", ""); w.Code != http.StatusConflict {
+			t.Fatalf("%s reservation of a checked out cart answered %d: %s", method, w.Code, w.Body)
+		}
+		if w = serve(r, method, "/cart/no-such-cart/reservation", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("%s reservation of an unknown cart answered %d: %s", method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPromotionAndCouponHandlers(t *testing.T) {
+	h, s, _, r := newTestHandler(t)
+	r.POST("/admin/coupon", h.AddCouponHandler)
+	r.GET("/admin/coupon", h.GetAllCouponsHandler)
+	r.POST("/admin/promotion", h.AddPromotionHandler)
+	r.GET("/admin/promotion", h.GetAllPromotionsHandler)
+	r.PUT("/admin/promotion/:promotionId", h.UpdatePromotionHandler)
+
+	productId, err := s.AddProduct(&structs.Product{Name: "Shirt", Category: "Men Shirts", Quantity: 100, Price: structs.Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	promotion := `{"name": "Third for free", "active": true, "rule": {"kind": "buy_x_get_y", "buy": 3, "free": 1}}`
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/admin/promotion", `{"name": "All for free", "active": true, "rule": {"kind": "buy_x_get_y", "buy": 3, "free": 3}}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/promotion", `{"name": "Bulk", "active": true, "rule": {"kind": "volume_tiers"}}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/promotion", `{"name":`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/promotion/no-such-promotion", promotion, http.StatusNotFound},
+		{http.MethodPost, "/admin/coupon", `{"code": "TEN", "kind": "percentage", "percent": 10}`, http.StatusOK},
+		{http.MethodPost, "/admin/coupon", `{"code": " ten ", "kind": "percentage", "percent": 20}`, http.StatusConflict},
+		{http.MethodPost, "/admin/coupon", `{"code": "HALF", "kind": "percentage", "percent": 150}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/coupon", `{"code": "GIFT", "kind": "free_item", "product_id": "no-such-product"}`, http.StatusBadRequest},
+	} {
+		if w := serve(r, tc.method, tc.path, tc.body); w.Code != tc.status {
+			t.Fatalf("%s %s with %s answered %d, expected %d: %s", tc.method, tc.path, tc.body, w.Code, tc.status, w.Body)
+		}
+	}
+
+	w := serve(r, http.MethodPost, "/admin/promotion", promotion)
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding a promotion answered %d: %s", w.Code, w.Body)
+	}
+	promotionId := strings.TrimPrefix(w.Body.String(), "Promotion successfully added id: ")
+
+	order := structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", CouponCode: "ten", Products: []structs.Product{{ID: productId, Quantity: 3}}}
+	orderId, err := s.AddOrder(&order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed, err := s.GetOrderById(orderId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.Price.Amount != 1800 || len(placed.Discounts) != 2 {
+		t.Fatalf("expected a shirt for free and 10%% off the rest, got %+v", placed)
+	}
+
+	w = serve(r, http.MethodGet, "/admin/coupon", "")
+	var coupons []structs.Coupon
+	if err = json.Unmarshal(w.Body.Bytes(), &coupons); err != nil {
+		t.Fatal(err)
+	}
+	if len(coupons) != 1 || coupons[0].Code != "TEN" || coupons[0].Uses != 1 {
+		t.Fatalf("expected coupon TEN redeemed once, got %+v", coupons)
+	}
+
+	ended := `{"name": "Third for free", "active": false, "rule": {"kind": "buy_x_get_y", "buy": 3, "free": 1}}`
+	if w = serve(r, http.MethodPut, "/admin/promotion/"+promotionId, ended); w.Code != http.StatusOK {
+		t.Fatalf("ending the promotion answered %d: %s", w.Code, w.Body)
+	}
+	if w = serve(r, http.MethodPut, "/admin/promotion/"+promotionId, `{"name": "", "rule": {"kind": "buy_x_get_y", "buy": 3, "free": 1}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("updating the promotion without a name answered %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(r, http.MethodGet, "/admin/promotion", "")
+	var promotions []structs.Promotion
+	if err = json.Unmarshal(w.Body.Bytes(), &promotions); err != nil {
+		t.Fatal(err)
+	}
+	if len(promotions) != 1 || promotions[0].ID != promotionId || promotions[0].Active {
+		t.Fatalf("expected the one promotion to have ended, got %+v", promotions)
+	}
+
+	order = structs.Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []structs.Product{{ID: productId, Quantity: 3}}}
+	if orderId, err = s.AddOrder(&order); err != nil {
+		t.Fatal(err)
+	}
+	if placed, err = s.GetOrderById(orderId, ""); err != nil || placed.Price.Amount != 3000 {
+		t.Fatalf("expected the ended promotion to no longer apply, got %+v (%v)", placed, err)
+	}
+}
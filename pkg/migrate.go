@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds one directory of NNNN_name.up.sql / NNNN_name.down.sql
+// files per dialect.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER      NOT NULL PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded migrations of the dialect ordered by version.
+func loadMigrations(dialect Dialect) ([]migration, error) {
+	dir := path.Join("migrations", string(dialect))
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %s", dialect, err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.%s.sql", name, direction)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s failed with: %s", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// statements returns the statements of a migration script to Exec one after
+// the other. SQLite and Postgres run a whole script in one Exec, the MySQL
+// driver only takes one statement at a time, so there it is split.
+func (d Dialect) statements(script string) []string {
+	if d != MySql {
+		return []string{script}
+	}
+
+	return splitStatements(script)
+}
+
+// splitStatements cuts a script at the semicolons outside of quotes and
+// comments, leaving the comments out. Stored programs with semicolons in
+// their body cannot be split this way and have no place in MySQL migrations.
+func splitStatements(script string) []string {
+	var statements []string
+	var statement strings.Builder
+	add := func() {
+		if s := strings.TrimSpace(statement.String()); s != "" {
+			statements = append(statements, s)
+		}
+		statement.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(script) && script[end] != c {
+				if script[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end++; end > len(script) {
+				end = len(script)
+			}
+			statement.WriteString(script[i:end])
+			i = end - 1
+		case c == '#' || c == '-' && strings.HasPrefix(script[i:], "--"):
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			statement.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			statement.WriteByte(' ')
+		case c == ';':
+			add()
+		default:
+			statement.WriteByte(c)
+		}
+	}
+	add()
+
+	return statements
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for an empty database.
+func (r *SqlRepository) SchemaVersion() (int, error) {
+	if _, err := r.exec(createSchemaMigrations); err != nil {
+		return 0, fmt.Errorf("creating schema_migrations failed with: %s", err)
+	}
+
+	var version int
+	if err := r.queryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version failed with: %s", err)
+	}
+
+	return version, nil
+}
+
+// MigrateUp applies every migration newer than the current schema version.
+func (r *SqlRepository) MigrateUp() error {
+	migrations, err := loadMigrations(r.dialect)
+	if err != nil {
+		return err
+	}
+
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err = r.applyMigration(m, m.up, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations.
+func (r *SqlRepository) MigrateDown(steps int) error {
+	migrations, err := loadMigrations(r.dialect)
+	if err != nil {
+		return err
+	}
+
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if m.version > current {
+			continue
+		}
+
+		if err = r.applyMigration(m, m.down, "DELETE FROM schema_migrations WHERE version = ?", m.version); err != nil {
+			return err
+		}
+		steps--
+	}
+
+	return nil
+}
+
+// applyMigration runs the statements of script and records the change in
+// schema_migrations within one transaction. MySQL commits DDL implicitly,
+// there the transaction only covers the bookkeeping.
+func (r *SqlRepository) applyMigration(m migration, script string, record string, args ...interface{}) error {
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("starting migration %04d_%s failed with: %s", m.version, m.name, err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range r.dialect.statements(script) {
+		if _, err = tx.Exec(statement); err != nil {
+			return fmt.Errorf("migration %04d_%s failed with: %s", m.version, m.name, err)
+		}
+	}
+
+	if _, err = tx.Exec(r.dialect.rebind(record), args...); err != nil {
+		return fmt.Errorf("recording migration %04d_%s failed with: %s", m.version, m.name, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing migration %04d_%s failed with: %s", m.version, m.name, err)
+	}
+
+	return nil
+}
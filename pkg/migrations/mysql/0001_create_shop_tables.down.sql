@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orderedProduct;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS products;
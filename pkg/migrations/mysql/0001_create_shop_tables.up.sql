@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS products (
+    id       CHAR(36)       NOT NULL PRIMARY KEY,
+    name     VARCHAR(255)   NOT NULL,
+    category VARCHAR(255)   NOT NULL,
+    quantity INT            NOT NULL,
+    price    DECIMAL(10, 2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id      CHAR(36)       NOT NULL PRIMARY KEY,
+    name    VARCHAR(255)   NOT NULL,
+    address VARCHAR(255)   NOT NULL,
+    phone   VARCHAR(32)    NOT NULL,
+    price   DECIMAL(10, 2) NOT NULL,
+    status  VARCHAR(32)    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orderedProduct (
+    id         CHAR(36) NOT NULL PRIMARY KEY,
+    product_id CHAR(36) NOT NULL,
+    quantity   INT      NOT NULL,
+    order_id   CHAR(36) NOT NULL,
+    FOREIGN KEY (product_id) REFERENCES products (id),
+    FOREIGN KEY (order_id) REFERENCES orders (id)
+);
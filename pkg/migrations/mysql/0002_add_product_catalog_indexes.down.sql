@@ -0,0 +1,4 @@
+DROP INDEX idx_products_quantity ON products;
+DROP INDEX idx_products_name ON products;
+DROP INDEX idx_products_price ON products;
+DROP INDEX idx_products_category ON products;
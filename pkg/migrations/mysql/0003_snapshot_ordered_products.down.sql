@@ -0,0 +1,3 @@
+ALTER TABLE orderedProduct DROP COLUMN price;
+ALTER TABLE orderedProduct DROP COLUMN category;
+ALTER TABLE orderedProduct DROP COLUMN name;
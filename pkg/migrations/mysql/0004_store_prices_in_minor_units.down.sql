@@ -0,0 +1,16 @@
+ALTER TABLE products ADD COLUMN price DECIMAL(10, 2) NOT NULL DEFAULT 0;
+UPDATE products SET price = price_amount / 100.0;
+DROP INDEX idx_products_price_amount ON products;
+ALTER TABLE products DROP COLUMN price_currency;
+ALTER TABLE products DROP COLUMN price_amount;
+CREATE INDEX idx_products_price ON products (price, id);
+
+ALTER TABLE orders ADD COLUMN price DECIMAL(10, 2) NOT NULL DEFAULT 0;
+UPDATE orders SET price = price_amount / 100.0;
+ALTER TABLE orders DROP COLUMN price_currency;
+ALTER TABLE orders DROP COLUMN price_amount;
+
+ALTER TABLE orderedProduct ADD COLUMN price DECIMAL(10, 2) NOT NULL DEFAULT 0;
+UPDATE orderedProduct SET price = price_amount / 100.0;
+ALTER TABLE orderedProduct DROP COLUMN price_currency;
+ALTER TABLE orderedProduct DROP COLUMN price_amount;
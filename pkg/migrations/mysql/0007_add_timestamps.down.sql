@@ -0,0 +1,5 @@
+DROP INDEX idx_orders_created_at ON orders;
+ALTER TABLE orders DROP COLUMN updated_at;
+ALTER TABLE orders DROP COLUMN created_at;
+ALTER TABLE products DROP COLUMN updated_at;
+ALTER TABLE products DROP COLUMN created_at;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          CHAR(36)    NOT NULL PRIMARY KEY,
+    order_id    CHAR(36)    NOT NULL,
+    from_status VARCHAR(32) NULL,
+    to_status   VARCHAR(32) NOT NULL,
+    changed_at  DATETIME(6) NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+CREATE INDEX idx_order_status_history_order ON order_status_history (order_id, changed_at);
+
+INSERT INTO order_status_history (id, order_id, from_status, to_status, changed_at)
+SELECT UUID(), id, NULL, status, created_at FROM orders;
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP FOREIGN KEY fk_orders_customer;
+DROP INDEX idx_orders_customer ON orders;
+ALTER TABLE orders DROP COLUMN customer_id;
+DROP TABLE IF EXISTS customers;
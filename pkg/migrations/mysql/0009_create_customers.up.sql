@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS customers (
+    id         CHAR(36)     NOT NULL PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    address    VARCHAR(255) NOT NULL,
+    phone      VARCHAR(32)  NOT NULL,
+    created_at DATETIME(6)  NOT NULL,
+    updated_at DATETIME(6)  NOT NULL
+);
+
+ALTER TABLE orders ADD COLUMN customer_id CHAR(36) NULL;
+CREATE INDEX idx_orders_customer ON orders (customer_id, id);
+ALTER TABLE orders ADD CONSTRAINT fk_orders_customer FOREIGN KEY (customer_id) REFERENCES customers (id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS users (
+    id            CHAR(36)     NOT NULL PRIMARY KEY,
+    email         VARCHAR(255) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role          VARCHAR(32)  NOT NULL,
+    customer_id   CHAR(36)     NULL,
+    created_at    DATETIME(6)  NOT NULL,
+    updated_at    DATETIME(6)  NOT NULL,
+    FOREIGN KEY (customer_id) REFERENCES customers (id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+    id         CHAR(36)    NOT NULL PRIMARY KEY,
+    user_id    CHAR(36)    NOT NULL,
+    token_hash CHAR(64)    NOT NULL UNIQUE,
+    expires_at DATETIME(6) NOT NULL,
+    used_at    DATETIME(6) NULL,
+    created_at DATETIME(6) NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
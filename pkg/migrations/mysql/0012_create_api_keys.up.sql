@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           CHAR(36)     NOT NULL PRIMARY KEY,
+    name         VARCHAR(255) NOT NULL,
+    prefix       VARCHAR(16)  NOT NULL,
+    key_hash     CHAR(64)     NOT NULL UNIQUE,
+    scopes       VARCHAR(255) NOT NULL,
+    created_at   DATETIME(6)  NOT NULL,
+    last_used_at DATETIME(6)  NULL,
+    revoked_at   DATETIME(6)  NULL
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id          CHAR(36)    NOT NULL PRIMARY KEY,
+    customer_id CHAR(36)    NULL,
+    order_id    CHAR(36)    NULL,
+    created_at  DATETIME(6) NOT NULL,
+    updated_at  DATETIME(6) NOT NULL,
+    FOREIGN KEY (customer_id) REFERENCES customers (id)
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id         CHAR(36)    NOT NULL PRIMARY KEY,
+    cart_id    CHAR(36)    NOT NULL,
+    product_id CHAR(36)    NOT NULL,
+    quantity   INT         NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    UNIQUE (cart_id, product_id),
+    FOREIGN KEY (cart_id) REFERENCES carts (id),
+    FOREIGN KEY (product_id) REFERENCES products (id)
+);
@@ -0,0 +1,14 @@
+ALTER TABLE products ADD COLUMN reserved INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reservations (
+    id         CHAR(36)    NOT NULL PRIMARY KEY,
+    cart_id    CHAR(36)    NOT NULL,
+    product_id CHAR(36)    NOT NULL,
+    quantity   INT         NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    FOREIGN KEY (cart_id) REFERENCES carts (id),
+    FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX idx_reservations_cart ON reservations (cart_id);
+CREATE INDEX idx_reservations_expires_at ON reservations (expires_at);
@@ -0,0 +1,45 @@
+ALTER TABLE orders ADD COLUMN subtotal_amount BIGINT NOT NULL DEFAULT 0;
+UPDATE orders SET subtotal_amount = price_amount;
+
+CREATE TABLE IF NOT EXISTS coupons (
+    id                    CHAR(36)     NOT NULL PRIMARY KEY,
+    code                  VARCHAR(64)  NOT NULL UNIQUE,
+    kind                  VARCHAR(32)  NOT NULL,
+    percent               INT          NOT NULL DEFAULT 0,
+    amount                BIGINT       NOT NULL DEFAULT 0,
+    currency              CHAR(3)      NOT NULL DEFAULT '',
+    product_id            CHAR(36)     NULL,
+    category              VARCHAR(255) NOT NULL DEFAULT '',
+    valid_from            DATETIME(6)  NULL,
+    valid_until           DATETIME(6)  NULL,
+    max_uses              INT          NOT NULL DEFAULT 0,
+    max_uses_per_customer INT          NOT NULL DEFAULT 0,
+    uses                  INT          NOT NULL DEFAULT 0,
+    created_at            DATETIME(6)  NOT NULL,
+    FOREIGN KEY (product_id) REFERENCES products (id)
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id          CHAR(36)    NOT NULL PRIMARY KEY,
+    coupon_id   CHAR(36)    NOT NULL,
+    order_id    CHAR(36)    NOT NULL,
+    customer_id CHAR(36)    NULL,
+    use_number  INT         NULL,
+    created_at  DATETIME(6) NOT NULL,
+    UNIQUE (coupon_id, customer_id, use_number),
+    FOREIGN KEY (coupon_id) REFERENCES coupons (id),
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_discounts (
+    id          CHAR(36)     NOT NULL PRIMARY KEY,
+    order_id    CHAR(36)     NOT NULL,
+    position    INT          NOT NULL,
+    source      VARCHAR(32)  NOT NULL,
+    code        VARCHAR(64)  NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    amount      BIGINT       NOT NULL,
+    currency    CHAR(3)      NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+CREATE INDEX idx_order_discounts_order ON order_discounts (order_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id          CHAR(36)     NOT NULL PRIMARY KEY,
+    name        VARCHAR(255) NOT NULL,
+    rule        TEXT         NOT NULL,
+    active      BOOLEAN      NOT NULL DEFAULT TRUE,
+    valid_from  DATETIME(6)  NULL,
+    valid_until DATETIME(6)  NULL,
+    created_at  DATETIME(6)  NOT NULL,
+    updated_at  DATETIME(6)  NOT NULL
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS order_promotions (
+    id           CHAR(36)     NOT NULL PRIMARY KEY,
+    order_id     CHAR(36)     NOT NULL,
+    position     INT          NOT NULL,
+    promotion_id CHAR(36)     NOT NULL,
+    name         VARCHAR(255) NOT NULL,
+    rule         TEXT         NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    FOREIGN KEY (promotion_id) REFERENCES promotions (id)
+);
+CREATE INDEX idx_order_promotions_order ON order_promotions (order_id, position);
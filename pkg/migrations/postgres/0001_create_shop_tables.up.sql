@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS products (
+    id       UUID           NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name     TEXT           NOT NULL,
+    category TEXT           NOT NULL,
+    quantity INTEGER        NOT NULL,
+    price    NUMERIC(10, 2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id      UUID           NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name    TEXT           NOT NULL,
+    address TEXT           NOT NULL,
+    phone   TEXT           NOT NULL,
+    price   NUMERIC(10, 2) NOT NULL,
+    status  TEXT           NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orderedProduct (
+    id         UUID    NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID    NOT NULL REFERENCES products (id),
+    quantity   INTEGER NOT NULL,
+    order_id   UUID    NOT NULL REFERENCES orders (id)
+);
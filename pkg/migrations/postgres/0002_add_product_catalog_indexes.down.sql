@@ -0,0 +1,4 @@
+DROP INDEX idx_products_quantity;
+DROP INDEX idx_products_name;
+DROP INDEX idx_products_price;
+DROP INDEX idx_products_category;
@@ -0,0 +1,9 @@
+ALTER TABLE orderedProduct ADD COLUMN name TEXT NOT NULL DEFAULT '';
+ALTER TABLE orderedProduct ADD COLUMN category TEXT NOT NULL DEFAULT '';
+ALTER TABLE orderedProduct ADD COLUMN price NUMERIC(10, 2) NOT NULL DEFAULT 0;
+
+UPDATE orderedProduct
+SET name     = (SELECT p.name FROM products p WHERE p.id = orderedProduct.product_id),
+    category = (SELECT p.category FROM products p WHERE p.id = orderedProduct.product_id),
+    price    = (SELECT p.price FROM products p WHERE p.id = orderedProduct.product_id)
+WHERE EXISTS (SELECT 1 FROM products p WHERE p.id = orderedProduct.product_id);
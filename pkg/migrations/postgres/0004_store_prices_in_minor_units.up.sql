@@ -0,0 +1,16 @@
+ALTER TABLE products ADD COLUMN price_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN price_currency CHAR(3) NOT NULL DEFAULT 'EUR';
+UPDATE products SET price_amount = ROUND(price * 100);
+DROP INDEX idx_products_price;
+ALTER TABLE products DROP COLUMN price;
+CREATE INDEX idx_products_price_amount ON products (price_amount, id);
+
+ALTER TABLE orders ADD COLUMN price_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN price_currency CHAR(3) NOT NULL DEFAULT 'EUR';
+UPDATE orders SET price_amount = ROUND(price * 100);
+ALTER TABLE orders DROP COLUMN price;
+
+ALTER TABLE orderedProduct ADD COLUMN price_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orderedProduct ADD COLUMN price_currency CHAR(3) NOT NULL DEFAULT 'EUR';
+UPDATE orderedProduct SET price_amount = ROUND(price * 100);
+ALTER TABLE orderedProduct DROP COLUMN price;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id    UUID                     NOT NULL REFERENCES orders (id),
+    from_status TEXT                     NULL,
+    to_status   TEXT                     NOT NULL,
+    changed_at  TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX idx_order_status_history_order ON order_status_history (order_id, changed_at);
+
+INSERT INTO order_status_history (order_id, from_status, to_status, changed_at)
+SELECT id, NULL, status, created_at FROM orders;
@@ -0,0 +1,3 @@
+DROP INDEX idx_orders_customer;
+ALTER TABLE orders DROP COLUMN customer_id;
+DROP TABLE IF EXISTS customers;
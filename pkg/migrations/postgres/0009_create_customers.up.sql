@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS customers (
+    id         UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name       TEXT                     NOT NULL,
+    address    TEXT                     NOT NULL,
+    phone      TEXT                     NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE orders ADD COLUMN customer_id UUID NULL REFERENCES customers (id);
+CREATE INDEX idx_orders_customer ON orders (customer_id, id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id            UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    email         TEXT                     NOT NULL UNIQUE,
+    password_hash TEXT                     NOT NULL,
+    role          TEXT                     NOT NULL,
+    customer_id   UUID                     NULL REFERENCES customers (id),
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at    TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name         TEXT                     NOT NULL,
+    prefix       TEXT                     NOT NULL,
+    key_hash     TEXT                     NOT NULL UNIQUE,
+    scopes       TEXT                     NOT NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE NULL,
+    revoked_at   TIMESTAMP WITH TIME ZONE NULL
+);
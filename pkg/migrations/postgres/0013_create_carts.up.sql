@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id          UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    customer_id UUID                     NULL REFERENCES customers (id),
+    order_id    UUID                     NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id         UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    cart_id    UUID                     NOT NULL REFERENCES carts (id),
+    product_id UUID                     NOT NULL REFERENCES products (id),
+    quantity   INTEGER                  NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (cart_id, product_id)
+);
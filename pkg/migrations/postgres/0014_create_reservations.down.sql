@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reservations;
+ALTER TABLE products DROP COLUMN reserved;
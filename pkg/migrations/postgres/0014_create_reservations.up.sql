@@ -0,0 +1,12 @@
+ALTER TABLE products ADD COLUMN reserved INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reservations (
+    id         UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    cart_id    UUID                     NOT NULL REFERENCES carts (id),
+    product_id UUID                     NOT NULL REFERENCES products (id),
+    quantity   INTEGER                  NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX idx_reservations_cart ON reservations (cart_id);
+CREATE INDEX idx_reservations_expires_at ON reservations (expires_at);
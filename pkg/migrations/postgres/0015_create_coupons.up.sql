@@ -0,0 +1,41 @@
+ALTER TABLE orders ADD COLUMN subtotal_amount BIGINT NOT NULL DEFAULT 0;
+UPDATE orders SET subtotal_amount = price_amount;
+
+CREATE TABLE IF NOT EXISTS coupons (
+    id                    UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    code                  TEXT                     NOT NULL UNIQUE,
+    kind                  TEXT                     NOT NULL,
+    percent               INTEGER                  NOT NULL DEFAULT 0,
+    amount                BIGINT                   NOT NULL DEFAULT 0,
+    currency              CHAR(3)                  NOT NULL DEFAULT '',
+    product_id            UUID                     NULL REFERENCES products (id),
+    category              TEXT                     NOT NULL DEFAULT '',
+    valid_from            TIMESTAMP WITH TIME ZONE NULL,
+    valid_until           TIMESTAMP WITH TIME ZONE NULL,
+    max_uses              INTEGER                  NOT NULL DEFAULT 0,
+    max_uses_per_customer INTEGER                  NOT NULL DEFAULT 0,
+    uses                  INTEGER                  NOT NULL DEFAULT 0,
+    created_at            TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id          UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    coupon_id   UUID                     NOT NULL REFERENCES coupons (id),
+    order_id    UUID                     NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    customer_id UUID                     NULL,
+    use_number  INTEGER                  NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (coupon_id, customer_id, use_number)
+);
+
+CREATE TABLE IF NOT EXISTS order_discounts (
+    id          UUID    NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id    UUID    NOT NULL REFERENCES orders (id),
+    position    INTEGER NOT NULL,
+    source      TEXT    NOT NULL,
+    code        TEXT    NOT NULL,
+    description TEXT    NOT NULL,
+    amount      BIGINT  NOT NULL,
+    currency    CHAR(3) NOT NULL
+);
+CREATE INDEX idx_order_discounts_order ON order_discounts (order_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id          UUID                     NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name        TEXT                     NOT NULL,
+    rule        TEXT                     NOT NULL,
+    active      BOOLEAN                  NOT NULL DEFAULT TRUE,
+    valid_from  TIMESTAMP WITH TIME ZONE NULL,
+    valid_until TIMESTAMP WITH TIME ZONE NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL
+);
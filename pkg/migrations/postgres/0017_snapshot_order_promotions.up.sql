@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS order_promotions (
+    id           UUID    NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id     UUID    NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    position     INTEGER NOT NULL,
+    promotion_id UUID    NOT NULL REFERENCES promotions (id),
+    name         TEXT    NOT NULL,
+    rule         TEXT    NOT NULL
+);
+CREATE INDEX idx_order_promotions_order ON order_promotions (order_id, position);
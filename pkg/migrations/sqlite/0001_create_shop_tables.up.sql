@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS products (
+    id       TEXT    NOT NULL PRIMARY KEY,
+    name     TEXT    NOT NULL,
+    category TEXT    NOT NULL,
+    quantity INTEGER NOT NULL,
+    price    REAL    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id      TEXT NOT NULL PRIMARY KEY,
+    name    TEXT NOT NULL,
+    address TEXT NOT NULL,
+    phone   TEXT NOT NULL,
+    price   REAL NOT NULL,
+    status  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orderedProduct (
+    id         TEXT    NOT NULL PRIMARY KEY,
+    product_id TEXT    NOT NULL REFERENCES products (id),
+    quantity   INTEGER NOT NULL,
+    order_id   TEXT    NOT NULL REFERENCES orders (id)
+);
@@ -0,0 +1,4 @@
+CREATE INDEX idx_products_category ON products (category);
+CREATE INDEX idx_products_price ON products (price, id);
+CREATE INDEX idx_products_name ON products (name, id);
+CREATE INDEX idx_products_quantity ON products (quantity, id);
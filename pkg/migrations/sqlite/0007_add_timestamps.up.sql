@@ -0,0 +1,9 @@
+-- SQLite only accepts constant defaults on added columns, existing rows get
+-- the time of the migration in the format the driver writes.
+ALTER TABLE products ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+ALTER TABLE products ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+UPDATE products SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'), updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+ALTER TABLE orders ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+ALTER TABLE orders ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+UPDATE orders SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'), updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+CREATE INDEX idx_orders_created_at ON orders (created_at, id);
@@ -0,0 +1,27 @@
+-- SQLite cannot drop a column that references another table, orders is
+-- rebuilt without customer_id instead. Its order lines and history keep
+-- pointing at it, their foreign keys are checked once the orders are back.
+PRAGMA defer_foreign_keys = ON;
+
+CREATE TABLE orders_without_customers AS
+SELECT id, name, address, phone, status, price_amount, price_currency, version, created_at, updated_at FROM orders;
+DROP TABLE orders;
+
+CREATE TABLE orders (
+    id             TEXT      NOT NULL PRIMARY KEY,
+    name           TEXT      NOT NULL,
+    address        TEXT      NOT NULL,
+    phone          TEXT      NOT NULL,
+    status         TEXT      NOT NULL,
+    price_amount   INTEGER   NOT NULL DEFAULT 0,
+    price_currency TEXT      NOT NULL DEFAULT 'EUR',
+    version        INTEGER   NOT NULL DEFAULT 1,
+    created_at     TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00',
+    updated_at     TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00'
+);
+INSERT INTO orders (id, name, address, phone, status, price_amount, price_currency, version, created_at, updated_at)
+SELECT id, name, address, phone, status, price_amount, price_currency, version, created_at, updated_at FROM orders_without_customers;
+DROP TABLE orders_without_customers;
+CREATE INDEX idx_orders_created_at ON orders (created_at, id);
+
+DROP TABLE IF EXISTS customers;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS customers (
+    id         TEXT      NOT NULL PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    address    TEXT      NOT NULL,
+    phone      TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE orders ADD COLUMN customer_id TEXT NULL REFERENCES customers (id);
+CREATE INDEX idx_orders_customer ON orders (customer_id, id);
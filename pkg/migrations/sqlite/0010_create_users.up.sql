@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id            TEXT      NOT NULL PRIMARY KEY,
+    email         TEXT      NOT NULL UNIQUE,
+    password_hash TEXT      NOT NULL,
+    role          TEXT      NOT NULL,
+    customer_id   TEXT      NULL REFERENCES customers (id),
+    created_at    TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN token_version;
+DROP TABLE IF EXISTS password_resets;
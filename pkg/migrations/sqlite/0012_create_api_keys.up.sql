@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           TEXT      NOT NULL PRIMARY KEY,
+    name         TEXT      NOT NULL,
+    prefix       TEXT      NOT NULL,
+    key_hash     TEXT      NOT NULL UNIQUE,
+    scopes       TEXT      NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at   TIMESTAMP NULL
+);
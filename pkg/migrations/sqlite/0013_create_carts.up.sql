@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id          TEXT      NOT NULL PRIMARY KEY,
+    customer_id TEXT      NULL REFERENCES customers (id),
+    order_id    TEXT      NULL,
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id         TEXT      NOT NULL PRIMARY KEY,
+    cart_id    TEXT      NOT NULL REFERENCES carts (id),
+    product_id TEXT      NOT NULL REFERENCES products (id),
+    quantity   INTEGER   NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (cart_id, product_id)
+);
@@ -0,0 +1,12 @@
+ALTER TABLE products ADD COLUMN reserved INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reservations (
+    id         TEXT      NOT NULL PRIMARY KEY,
+    cart_id    TEXT      NOT NULL REFERENCES carts (id),
+    product_id TEXT      NOT NULL REFERENCES products (id),
+    quantity   INTEGER   NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_reservations_cart ON reservations (cart_id);
+CREATE INDEX idx_reservations_expires_at ON reservations (expires_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupons;
+ALTER TABLE orders DROP COLUMN subtotal_amount;
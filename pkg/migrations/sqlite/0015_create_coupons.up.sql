@@ -0,0 +1,41 @@
+ALTER TABLE orders ADD COLUMN subtotal_amount INTEGER NOT NULL DEFAULT 0;
+UPDATE orders SET subtotal_amount = price_amount;
+
+CREATE TABLE IF NOT EXISTS coupons (
+    id                    TEXT      NOT NULL PRIMARY KEY,
+    code                  TEXT      NOT NULL UNIQUE,
+    kind                  TEXT      NOT NULL,
+    percent               INTEGER   NOT NULL DEFAULT 0,
+    amount                INTEGER   NOT NULL DEFAULT 0,
+    currency              TEXT      NOT NULL DEFAULT '',
+    product_id            TEXT      NULL REFERENCES products (id),
+    category              TEXT      NOT NULL DEFAULT '',
+    valid_from            TIMESTAMP NULL,
+    valid_until           TIMESTAMP NULL,
+    max_uses              INTEGER   NOT NULL DEFAULT 0,
+    max_uses_per_customer INTEGER   NOT NULL DEFAULT 0,
+    uses                  INTEGER   NOT NULL DEFAULT 0,
+    created_at            TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id          TEXT      NOT NULL PRIMARY KEY,
+    coupon_id   TEXT      NOT NULL REFERENCES coupons (id),
+    order_id    TEXT      NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    customer_id TEXT      NULL,
+    use_number  INTEGER   NULL,
+    created_at  TIMESTAMP NOT NULL,
+    UNIQUE (coupon_id, customer_id, use_number)
+);
+
+CREATE TABLE IF NOT EXISTS order_discounts (
+    id          TEXT    NOT NULL PRIMARY KEY,
+    order_id    TEXT    NOT NULL REFERENCES orders (id),
+    position    INTEGER NOT NULL,
+    source      TEXT    NOT NULL,
+    code        TEXT    NOT NULL,
+    description TEXT    NOT NULL,
+    amount      INTEGER NOT NULL,
+    currency    TEXT    NOT NULL
+);
+CREATE INDEX idx_order_discounts_order ON order_discounts (order_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id          TEXT      NOT NULL PRIMARY KEY,
+    name        TEXT      NOT NULL,
+    rule        TEXT      NOT NULL,
+    active      BOOLEAN   NOT NULL DEFAULT TRUE,
+    valid_from  TIMESTAMP NULL,
+    valid_until TIMESTAMP NULL,
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS order_promotions (
+    id           TEXT    NOT NULL PRIMARY KEY,
+    order_id     TEXT    NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    position     INTEGER NOT NULL,
+    promotion_id TEXT    NOT NULL REFERENCES promotions (id),
+    name         TEXT    NOT NULL,
+    rule         TEXT    NOT NULL
+);
+CREATE INDEX idx_order_promotions_order ON order_promotions (order_id, position);
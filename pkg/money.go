@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DefaultCurrency is the currency prices are kept in when none is given.
+const DefaultCurrency = "EUR"
+
+// minorUnits is the number of minor units in a major one. All currencies the
+// shop supports have two decimals.
+const minorUnits = 100
+
+// Money is an exact amount in the minor units (cents) of Currency.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ParseMoney reads a decimal amount such as 19.99 in the given currency.
+// Amounts with more decimals than the currency has are rejected.
+func ParseMoney(value string, currency string) (Money, error) {
+	amount, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return Money{}, fmt.Errorf("invalid amount: %s", value)
+	}
+
+	amount.Mul(amount, big.NewRat(minorUnits, 1))
+	if !amount.IsInt() || !amount.Num().IsInt64() {
+		return Money{}, fmt.Errorf("invalid amount: %s", value)
+	}
+
+	return Money{Amount: amount.Num().Int64(), Currency: currency}, nil
+}
+
+// Add returns the sum of both amounts, which must share a currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Times returns the amount multiplied by quantity.
+func (m Money) Times(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// Convert exchanges the amount into currency at the given rate, rounding half
+// away from zero to whole minor units.
+func (m Money) Convert(rate *big.Rat, currency string) Money {
+	exact := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+
+	quotient, remainder := new(big.Int).QuoRem(exact.Num(), exact.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(exact.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(exact.Sign())))
+	}
+
+	return Money{Amount: quotient.Int64(), Currency: currency}
+}
+
+// String formats the amount with its decimals, e.g. 19.99 EUR.
+func (m Money) String() string {
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s%d.%02d %s", sign, amount/minorUnits, amount%minorUnits, m.Currency))
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers messages to the users of the shop, e.g. password reset tokens.
+type Notifier interface {
+	Notify(to string, subject string, body string) error
+}
+
+// LogNotifier writes messages to the log instead of delivering them. It stands
+// in for a real delivery channel during development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(to string, subject string, body string) error {
+	log.Printf("notification to %s: %s: %s", to, subject, body)
+	return nil
+}
+
+// FileNotifier appends messages to the file at Path, one per line.
+type FileNotifier struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (n *FileNotifier) Notify(to string, subject string, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening notification file failed with: %s", err)
+	}
+	defer file.Close()
+
+	if _, err = fmt.Fprintf(file, "%s\t%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), to, subject, body); err != nil {
+		return fmt.Errorf("writing notification failed with: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// cursor marks the last row of a page. Listings are ordered by a sort column
+// and then by id, so the next page starts right after Value and ID. Sort
+// remembers the ordering the cursor was made for.
+type cursor struct {
+	Sort  string      `json:"sort,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	ID    string      `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor handed out with a previous page of a listing
+// with the given sort, nil for the first page.
+func decodeCursor(value string, sort string) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", value)
+	}
+
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.ID == "" || c.Sort != sort {
+		return nil, fmt.Errorf("invalid cursor: %s", value)
+	}
+
+	return &c, nil
+}
+
+// keyset returns the ORDER BY clause of a listing sorted by column and then
+// id, and the condition selecting the rows after the cursor. An empty column
+// sorts by id alone.
+func keyset(column string, descending bool, after *cursor) (orderBy string, condition string, args []interface{}) {
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if column == "" {
+		orderBy = fmt.Sprintf(" ORDER BY id %s", direction)
+		if after != nil {
+			condition, args = "id "+comparison+" ?", []interface{}{after.ID}
+		}
+		return orderBy, condition, args
+	}
+
+	orderBy = fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	if after != nil {
+		condition = fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, comparison, column, comparison)
+		args = []interface{}{after.Value, after.Value, after.ID}
+	}
+
+	return orderBy, condition, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// likePattern builds a LIKE pattern matching values that contain substring,
+// escaping the wildcards with !.
+func likePattern(substring string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(substring))
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+
+	_ "github.com/lib/pq"
+)
+
+// InitPostgresConnection connects to the online_shop database on POSTGRES_ADDRESS.
+func InitPostgresConnection() (*sql.DB, error) {
+	sslMode := os.Getenv("POSTGRES_SSLMODE")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD")),
+		Host:     os.Getenv("POSTGRES_ADDRESS"),
+		Path:     "online_shop",
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("database opening failed with error: %s", err.Error())
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("ping postgres failed with error: %s", err.Error())
+	}
+
+	return db, nil
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import "time"
+
+// ProductRepository is the storage contract for the products of the shop.
+type ProductRepository interface {
+	GetAllProducts(query ProductQuery) (*ProductPage, error)
+	GetProductById(productId string) (*Product, error)
+	AddProduct(product *Product) (string, error)
+	UpdateProduct(product *Product) error
+	DeleteProduct(productId string) error
+	RestoreProduct(productId string) error
+	ChangeProductQuantity(productId string, quantity int) error
+	RestockProduct(productId string, quantity int) error
+	ReserveProduct(productId string, quantity int) error
+	ReleaseProduct(productId string, quantity int) error
+}
+
+// OrderRepository is the storage contract for orders and the products ordered with them.
+type OrderRepository interface {
+	GetAllOrders(query OrderQuery) (*OrderPage, error)
+	GetOrderById(orderId string) (*Order, error)
+	AddOrder(order *Order) (string, error)
+	UpdateOrder(order *Order) error
+	UpdateOrderStatus(orderId string, from string, to string) error
+	DeleteOrder(orderId string) error
+	DeleteAllProductsForAnOrder(orderId string) error
+	GetAllProductsForOrder(orderId string) ([]Product, error)
+	AddOrderedProduct(op *OrderedProduct) error
+	DeleteOrderedProduct(orderId string, productId string) error
+	AddOrderStatusChange(change *OrderStatusChange) error
+	GetOrderStatusHistory(orderId string) ([]OrderStatusChange, error)
+	DeleteOrderStatusHistory(orderId string) error
+	AddOrderDiscount(discount *OrderDiscount) error
+	DeleteOrderDiscounts(orderId string) error
+	GetOrderPromotions(orderId string) ([]Promotion, error)
+	AddOrderPromotions(orderId string, promotions []Promotion) error
+	DeleteOrderPromotions(orderId string) error
+}
+
+// CustomerRepository is the storage contract for the customers of the shop.
+type CustomerRepository interface {
+	GetCustomerById(customerId string) (*Customer, error)
+	AddCustomer(customer *Customer) (string, error)
+	UpdateCustomer(customer *Customer) error
+}
+
+// UserRepository is the storage contract for the accounts that sign in to the shop.
+type UserRepository interface {
+	GetUserById(userId string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	AddUser(user *User) (string, error)
+	UpdateUserPassword(userId string, passwordHash string) error
+	AddPasswordReset(reset *PasswordReset) error
+	GetPasswordReset(tokenHash string) (*PasswordReset, error)
+	UsePasswordReset(resetId string) error
+}
+
+// CartRepository is the storage contract for carts and the products in them.
+type CartRepository interface {
+	GetCartById(cartId string) (*Cart, error)
+	AddCart(cart *Cart) (string, error)
+	AddCartItem(cartId string, item *CartItem) error
+	UpdateCartItem(cartId string, productId string, quantity int) error
+	DeleteCartItem(cartId string, productId string) error
+	CheckOutCart(cartId string, orderId string) error
+}
+
+// ReservationRepository is the storage contract for stock held for carts.
+type ReservationRepository interface {
+	GetReservationsForCart(cartId string) ([]Reservation, error)
+	GetExpiredReservations(before time.Time, limit int) ([]Reservation, error)
+	AddReservation(res *Reservation) error
+	DeleteReservation(reservationId string) error
+}
+
+// CouponRepository is the storage contract for discount codes and their redemptions.
+type CouponRepository interface {
+	GetAllCoupons() ([]Coupon, error)
+	GetCouponByCode(code string) (*Coupon, error)
+	AddCoupon(coupon *Coupon) (string, error)
+	UseCoupon(couponId string) error
+	ReleaseCoupon(couponId string) error
+	GetCouponUseNumbers(couponId string, customerId string) ([]int, error)
+	GetCouponRedemptionForOrder(orderId string) (*CouponRedemption, error)
+	AddCouponRedemption(redemption *CouponRedemption) error
+	DeleteCouponRedemption(redemptionId string) error
+}
+
+// PromotionRepository is the storage contract for automatic promotions.
+type PromotionRepository interface {
+	GetAllPromotions() ([]Promotion, error)
+	GetActivePromotions(at time.Time) ([]Promotion, error)
+	GetPromotionById(promotionId string) (*Promotion, error)
+	AddPromotion(promotion *Promotion) (string, error)
+	UpdatePromotion(promotion *Promotion) error
+}
+
+// APIKeyRepository is the storage contract for the API keys of other systems.
+type APIKeyRepository interface {
+	GetAllAPIKeys() ([]APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+	AddAPIKey(key *APIKey) (string, error)
+	RevokeAPIKey(keyId string) error
+	TouchAPIKey(keyId string) error
+}
+
+// Repositories groups the storage dependencies of the service layer.
+type Repositories struct {
+	Products     ProductRepository
+	Orders       OrderRepository
+	Customers    CustomerRepository
+	Users        UserRepository
+	APIKeys      APIKeyRepository
+	Carts        CartRepository
+	Reservations ReservationRepository
+	Coupons      CouponRepository
+	Promotions   PromotionRepository
+}
+
+// Transactor runs fn with repositories that share one database transaction.
+// The transaction is committed when fn returns nil and rolled back otherwise.
+type Transactor interface {
+	WithinTransaction(fn func(repos Repositories) error) error
+}
@@ -1,11 +1,20 @@
 package pkg
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/golang-rest-shop-backend/pkg/database"
-	"math"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"math/big"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 )
 
 type ExchangeRateAPIResponse struct {
@@ -14,104 +23,242 @@ type ExchangeRateAPIResponse struct {
 	Base      string `json:"base"`
 	Date      string `json:"date"`
 	Rates     struct {
-		BGN float64 `json:"BGN"`
-		CAD float64 `json:"CAD"`
-		CHF float64 `json:"CHF"`
-		EUR float64 `json:"EUR"`
-		GBP float64 `json:"GBP"`
-		USD float64 `json:"USD"`
+		BGN json.Number `json:"BGN"`
+		CAD json.Number `json:"CAD"`
+		CHF json.Number `json:"CHF"`
+		EUR json.Number `json:"EUR"`
+		GBP json.Number `json:"GBP"`
+		USD json.Number `json:"USD"`
 	} `json:"rates"`
 }
 
-func GetAllProducts(currency string) ([]Product, error) {
-	products, err := database.GetAllProducts()
+// Service implements the business logic of the shop on top of the storage
+// repositories. Messages to users are sent through notifier.
+type Service struct {
+	repos      database.Repositories
+	transactor database.Transactor
+	notifier   Notifier
+}
+
+func NewService(repos database.Repositories, transactor database.Transactor, notifier Notifier) *Service {
+	return &Service{repos: repos, transactor: transactor, notifier: notifier}
+}
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageLimit applies the default to an unset limit and caps it at maxPageLimit.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+
+	return limit
+}
+
+func (s *Service) GetAllProducts(query ProductQuery, currency string) (*ProductPage, error) {
+	query.Limit = pageLimit(query.Limit)
+
+	if query.PriceCurrency != "" {
+		if err := validatePrice(&Money{Currency: query.PriceCurrency}); err != nil {
+			return nil, fmt.Errorf("invalid price_currency: %s", query.PriceCurrency)
+		}
+	}
+
+	page, err := s.repos.Products.GetAllProducts(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all products with error: %s\n", err)
 	}
 
-	for i := range products {
-		err := convertPrice(&products[i], currency)
-		if err != nil {
+	rates, err := ratesFor(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range page.Products {
+		if err = convertPrice(&page.Products[i], currency, rates); err != nil {
 			return nil, err
 		}
 	}
 
-	return products, nil
+	return page, nil
 }
 
-func GetProductById(id string, currency string) (*Product, error) {
-	product, err := database.GetProductById(id)
+// GetProductById returns a product of the catalog, archived products are not found.
+func (s *Service) GetProductById(id string, currency string) (*Product, error) {
+	product, err := s.repos.Products.GetProductById(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find such product error: %s\n", err)
 	}
 
-	if err = convertPrice(&product, currency); err != nil {
+	if product.DeletedAt != nil {
+		return nil, fmt.Errorf("failed to find such product error: no product with id: %s\n", id)
+	}
+
+	rates, err := ratesFor(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = convertPrice(product, currency, rates); err != nil {
 		return nil, err
 	}
 
 	return product, nil
 }
 
-func GetAllOrders(currency string) ([]Order, error) {
-	orders, err := database.GetAllOrders()
+func (s *Service) GetAllOrders(query OrderQuery, currency string) (*OrderPage, error) {
+	query.Limit = pageLimit(query.Limit)
+
+	page, err := s.repos.Orders.GetAllOrders(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all products with error: %s\n", err)
+		return nil, fmt.Errorf("failed to get all orders with error: %s\n", err)
+	}
+
+	rates, err := ratesFor(currency)
+	if err != nil {
+		return nil, err
 	}
 
-	for i := range orders {
-		if err = convertPrice(&orders[i], currency); err != nil {
+	for i := range page.Orders {
+		if err = convertPrice(&page.Orders[i], currency, rates); err != nil {
 			return nil, err
 		}
 	}
 
-	return orders, nil
+	return page, nil
 }
 
-func GetOrderById(id string, currency string) (*Order, error) {
-	order, err := database.GetOrderById(id)
+func (s *Service) GetOrderById(id string, currency string) (*Order, error) {
+	order, err := s.repos.Orders.GetOrderById(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find such order error: %s\n", err)
 	}
 
-	if err = convertPrice(&order, currency); err != nil {
+	rates, err := ratesFor(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = convertPrice(order, currency, rates); err != nil {
 		return nil, err
 	}
 
 	return order, nil
 }
 
-func AddOrder(order *Order) (string, error) {
-	totalPrice := 0.0
+// AddOrder takes the ordered quantities out of stock and stores the order with
+// its products. Either all of it is written or, on any failure, none of it.
+func (s *Service) AddOrder(order *Order) (string, error) {
+	var orderId string
+
+	err := s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		var err error
+		orderId, err = placeOrder(repos, order)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return orderId, nil
+}
+
+// placeOrder takes the products out of stock and stores the order with a
+// snapshot of them, priced with the active promotions and the coupon of the
+// order. Delivery details missing from the order are taken from its customer.
+func placeOrder(repos database.Repositories, order *Order) (string, error) {
+	if len(order.Products) == 0 {
+		return "", fmt.Errorf("invalid order: an order needs at least one product")
+	}
+
+	if order.CustomerId != "" {
+		customer, err := repos.Customers.GetCustomerById(order.CustomerId)
+		if err != nil {
+			return "", err
+		}
+
+		if order.Name == "" {
+			order.Name = customer.Name
+		}
+		if order.Address == "" {
+			order.Address = customer.Address
+		}
+		if order.Phone == "" {
+			order.Phone = customer.Phone
+		}
+	}
+
+	lines := make([]OrderedProduct, 0, len(order.Products))
 
 	for _, p := range order.Products {
-		err := database.ChangeProductQuantity(p.ID, p.Quantity)
+		if p.Quantity <= 0 {
+			return "", fmt.Errorf("invalid quantity %d of product: %s", p.Quantity, p.ID)
+		}
+
+		err := repos.Products.ChangeProductQuantity(p.ID, p.Quantity)
 		if err != nil {
 			return "", err
 		}
 
-		product, err := database.GetProductById(p.ID)
+		line, err := snapshotProduct(repos, p.ID, p.Quantity)
 		if err != nil {
 			return "", err
 		}
+		lines = append(lines, line)
+	}
+
+	promotions, err := repos.Promotions.GetActivePromotions(time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	coupon, redemption, err := redeemCoupon(repos, order)
+	if err != nil {
+		return "", err
+	}
 
-		totalPrice += product.Price * float64(p.Quantity)
+	if err = priceOrder(order, lines, promotions, coupon); err != nil {
+		return "", err
 	}
 
-	order.Price = totalPrice
-	order.Status = "Accepted"
+	order.Status = StatusAccepted
 
-	orderId, err := database.AddOrder(order)
+	orderId, err := repos.Orders.AddOrder(order)
 	if err != nil {
 		return "", err
 	}
 
-	for _, p := range order.Products {
-		err = database.AddOrderedProduct(&OrderedProduct{
-			ProductId:       p.ID,
-			ProductQuantity: p.Quantity,
-			OrderId:         orderId,
-		})
-		if err != nil {
+	if redemption != nil {
+		redemption.OrderId = orderId
+		if err = repos.Coupons.AddCouponRedemption(redemption); err != nil {
+			if strings.HasPrefix(err.Error(), "coupon") {
+				return "", fmt.Errorf("invalid coupon: %s was already used by the customer", coupon.Code)
+			}
+			return "", err
+		}
+	}
+
+	if err = addOrderDiscounts(repos, orderId, order.Discounts); err != nil {
+		return "", err
+	}
+
+	if err = repos.Orders.AddOrderPromotions(orderId, promotions); err != nil {
+		return "", err
+	}
+
+	if err = repos.Orders.AddOrderStatusChange(&OrderStatusChange{OrderId: orderId, To: order.Status}); err != nil {
+		return "", err
+	}
+
+	for i := range lines {
+		lines[i].OrderId = orderId
+		if err = repos.Orders.AddOrderedProduct(&lines[i]); err != nil {
 			return "", err
 		}
 	}
@@ -119,8 +266,12 @@ func AddOrder(order *Order) (string, error) {
 	return orderId, nil
 }
 
-func AddProduct(product *Product) (string, error) {
-	productId, err := database.AddProduct(product)
+func (s *Service) AddProduct(product *Product) (string, error) {
+	if err := validatePrice(&product.Price); err != nil {
+		return "", err
+	}
+
+	productId, err := s.repos.Products.AddProduct(product)
 	if err != nil {
 		return "", err
 	}
@@ -128,8 +279,12 @@ func AddProduct(product *Product) (string, error) {
 	return productId, nil
 }
 
-func UpdateProduct(product *Product) error {
-	err := database.UpdateProduct(product)
+func (s *Service) UpdateProduct(product *Product) error {
+	if err := validatePrice(&product.Price); err != nil {
+		return err
+	}
+
+	err := s.repos.Products.UpdateProduct(product)
 	if err != nil {
 		return err
 	}
@@ -137,97 +292,1483 @@ func UpdateProduct(product *Product) error {
 	return nil
 }
 
-func UpdateOrder(order *Order) error {
-	err := database.UpdateOrder(order)
+// snapshotProduct makes an order line of the product as it is now.
+func snapshotProduct(repos database.Repositories, productId string, quantity int) (OrderedProduct, error) {
+	product, err := repos.Products.GetProductById(productId)
 	if err != nil {
-		return err
+		return OrderedProduct{}, err
 	}
 
-	return nil
+	return OrderedProduct{
+		ProductId:       productId,
+		ProductQuantity: quantity,
+		Name:            product.Name,
+		Category:        product.Category,
+		Price:           product.Price,
+	}, nil
+}
+
+// orderTotal sums the prices of the lines of an order.
+func orderTotal(lines []OrderedProduct) (Money, error) {
+	var total Money
+	for _, line := range lines {
+		if total.Currency == "" {
+			total.Currency = line.Price.Currency
+		}
+
+		var err error
+		if total, err = total.Add(line.Price.Times(line.ProductQuantity)); err != nil {
+			return Money{}, fmt.Errorf("products of an order must share one currency: %s", err)
+		}
+	}
+
+	return total, nil
+}
+
+// redeemCoupon checks that the coupon of the order can be redeemed on it,
+// counts the redemption and returns it to be stored with the order. It
+// returns nil when the order has no coupon.
+func redeemCoupon(repos database.Repositories, order *Order) (*Coupon, *CouponRedemption, error) {
+	if order.CouponCode == "" {
+		return nil, nil, nil
+	}
+
+	code := normalizeCouponCode(order.CouponCode)
+	coupon, err := repos.Coupons.GetCouponByCode(code)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no coupon") {
+			return nil, nil, fmt.Errorf("invalid coupon: unknown code %s", code)
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
+		return nil, nil, fmt.Errorf("invalid coupon: %s is not valid yet", code)
+	}
+	if coupon.ValidUntil != nil && !now.Before(*coupon.ValidUntil) {
+		return nil, nil, fmt.Errorf("invalid coupon: %s has expired", code)
+	}
+
+	redemption := &CouponRedemption{CouponId: coupon.ID, CustomerId: order.CustomerId}
+
+	// A customer takes the lowest use number that is free, the unique key on
+	// it stops a concurrent order from taking the same one.
+	if coupon.MaxUsesPerCustomer > 0 {
+		if order.CustomerId == "" {
+			return nil, nil, fmt.Errorf("invalid coupon: %s is for orders of customers only", code)
+		}
+
+		taken, err := repos.Coupons.GetCouponUseNumbers(coupon.ID, order.CustomerId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		redemption.UseNumber = 1
+		for _, n := range taken {
+			if n == redemption.UseNumber {
+				redemption.UseNumber++
+			}
+		}
+		if redemption.UseNumber > coupon.MaxUsesPerCustomer {
+			return nil, nil, fmt.Errorf("invalid coupon: %s was already used by the customer", code)
+		}
+	}
+
+	if err = repos.Coupons.UseCoupon(coupon.ID); err != nil {
+		if strings.HasPrefix(err.Error(), "coupon") {
+			return nil, nil, fmt.Errorf("invalid coupon: %s is used up", code)
+		}
+		return nil, nil, err
+	}
+
+	order.CouponCode = coupon.Code
+
+	return coupon, redemption, nil
 }
 
-func DeleteOrder(orderId string) error {
-	if err := database.DeleteAllProductsForAnOrder(orderId); err != nil {
+// releaseCoupon gives the coupon use of the order back, so a cancelled or
+// returned order does not count against the limits of its coupon.
+func releaseCoupon(repos database.Repositories, orderId string) error {
+	redemption, err := repos.Coupons.GetCouponRedemptionForOrder(orderId)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no coupon redemption") {
+			return nil
+		}
 		return err
 	}
 
-	if err := database.DeleteOrder(orderId); err != nil {
+	if err = repos.Coupons.DeleteCouponRedemption(redemption.ID); err != nil {
 		return err
 	}
 
-	return nil
+	return repos.Coupons.ReleaseCoupon(redemption.CouponId)
 }
 
-func DeleteProduct(productId string) error {
-	if err := database.DeleteProduct(productId); err != nil {
+// priceOrder sets the subtotal of the lines on the order, the discounts of
+// the promotions that apply to them and of the coupon when there is one, and
+// the price that is left. Promotions are taken off first, in their order, and
+// the coupon off what is left.
+func priceOrder(order *Order, lines []OrderedProduct, promotions []Promotion, coupon *Coupon) error {
+	subtotal, err := orderTotal(lines)
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	order.Subtotal = subtotal
+	order.Price = subtotal
+	order.Discounts = nil
 
-func convertPrice(object interface{}, currency string) error {
-	if currency == "" {
+	discount := func(source string, code string, description string, amount Money) {
+		if amount.Amount > order.Price.Amount {
+			amount.Amount = order.Price.Amount
+		}
+		order.Price.Amount -= amount.Amount
+
+		order.Discounts = append(order.Discounts, OrderDiscount{
+			Position:    len(order.Discounts),
+			Source:      source,
+			Code:        code,
+			Description: description,
+			Amount:      amount,
+		})
+	}
+
+	for _, promotion := range promotions {
+		if amount, description := promotionDiscount(promotion.Rule, lines); amount.Amount > 0 {
+			discount(DiscountPromotion, promotion.ID, promotion.Name+": "+description, amount)
+		}
+	}
+
+	if coupon == nil {
 		return nil
 	}
 
-	rate, err := getRates(currency)
+	amount, description, err := couponDiscount(coupon, lines, order.Price)
 	if err != nil {
 		return err
 	}
+	discount(DiscountCoupon, coupon.Code, description, amount)
 
-	switch v := object.(type) {
-	case *Order:
-		{
-			v.Price = math.Round(rate*v.Price*100) / 100
-			for i := range v.Products {
-				v.Products[i].Price = math.Round(rate*v.Products[i].Price*100) / 100
+	return nil
+}
+
+// promotionDiscount returns how much the rule takes off an order with the
+// lines and what for, nothing when the lines do not qualify for it.
+func promotionDiscount(rule PromotionRule, lines []OrderedProduct) (Money, string) {
+	var units []Money
+	matched := Money{}
+	quantity := 0
+	for _, line := range lines {
+		if (rule.ProductId != "" && line.ProductId != rule.ProductId) || (rule.Category != "" && line.Category != rule.Category) {
+			continue
+		}
+
+		for i := 0; i < line.ProductQuantity; i++ {
+			units = append(units, line.Price)
+		}
+		matched.Currency = line.Price.Currency
+		matched.Amount += line.Price.Times(line.ProductQuantity).Amount
+		quantity += line.ProductQuantity
+	}
+
+	switch rule.Kind {
+	case PromotionBuyXGetY:
+		if rule.Buy <= 0 || len(units) < rule.Buy {
+			return Money{}, ""
+		}
+
+		sort.Slice(units, func(i, j int) bool { return units[i].Amount > units[j].Amount })
+
+		free := Money{Currency: matched.Currency}
+		count := 0
+		for group := 0; (group+1)*rule.Buy <= len(units); group++ {
+			for _, unit := range units[(group+1)*rule.Buy-rule.Free : (group+1)*rule.Buy] {
+				free.Amount += unit.Amount
+				count++
 			}
 		}
-	case *Product:
-		{
-			v.Price = math.Round(rate*v.Price*100) / 100
+		return free, fmt.Sprintf("%d of %d items for free", count, len(units))
+	case PromotionVolumeTiers:
+		percent := 0
+		for _, tier := range rule.Tiers {
+			if quantity >= tier.MinQuantity && tier.Percent > percent {
+				percent = tier.Percent
+			}
 		}
-	default:
-		return fmt.Errorf("unsupported type")
+		if percent == 0 {
+			return Money{}, ""
+		}
+		return percentOf(matched, percent), fmt.Sprintf("%d%% off %d items", percent, quantity)
 	}
 
-	return nil
+	return Money{}, ""
+}
+
+// couponDiscount returns how much the coupon takes off an order with the
+// lines and a price of price, and what for. Percentages are rounded down to
+// whole minor units.
+func couponDiscount(coupon *Coupon, lines []OrderedProduct, price Money) (Money, string, error) {
+	switch coupon.Kind {
+	case CouponPercentage:
+		return percentOf(price, coupon.Percent), fmt.Sprintf("%d%% off the order", coupon.Percent), nil
+	case CouponFixed:
+		if coupon.Amount.Currency != price.Currency {
+			return Money{}, "", fmt.Errorf("invalid coupon: %s is for orders in %s", coupon.Code, coupon.Amount.Currency)
+		}
+		return coupon.Amount, fmt.Sprintf("%s off the order", coupon.Amount), nil
+	case CouponFreeItem:
+		for _, line := range lines {
+			if line.ProductId == coupon.ProductId {
+				return line.Price, fmt.Sprintf("one %s for free", line.Name), nil
+			}
+		}
+		return Money{}, "", fmt.Errorf("invalid coupon: %s needs product %s in the order", coupon.Code, coupon.ProductId)
+	case CouponCategory:
+		matched := Money{Currency: price.Currency}
+		found := false
+		for _, line := range lines {
+			if line.Category == coupon.Category {
+				matched.Amount += line.Price.Times(line.ProductQuantity).Amount
+				found = true
+			}
+		}
+		if !found {
+			return Money{}, "", fmt.Errorf("invalid coupon: %s needs products of category %s in the order", coupon.Code, coupon.Category)
+		}
+		return percentOf(matched, coupon.Percent), fmt.Sprintf("%d%% off %s", coupon.Percent, coupon.Category), nil
+	}
+
+	return Money{}, "", fmt.Errorf("invalid coupon: %s has unknown kind %s", coupon.Code, coupon.Kind)
 }
 
-func getRates(currency string) (float64, error) {
-	const accessKey = "a3d5d57407a65c0b4fa4853c2e5cbe07"
-	url := fmt.Sprintf("http://api.exchangeratesapi.io/v1/latest?access_key=%s&format=1", accessKey)
+func percentOf(m Money, percent int) Money {
+	return Money{Amount: m.Amount * int64(percent) / 100, Currency: m.Currency}
+}
 
-	resp, err := http.Get(url)
+// repriceOrder prices the order with its new lines and the coupon it was
+// placed with, and stores the discounts that follow. Promotions are those that
+// were active when the order was placed, as they were then, so changing a
+// promotion later leaves the order alone, while a promotion of that time the
+// new lines qualify for applies.
+func repriceOrder(repos database.Repositories, order *Order, lines []OrderedProduct) error {
+	promotions, err := repos.Orders.GetOrderPromotions(order.ID)
 	if err != nil {
-		return 0, fmt.Errorf("request to exchange rates API failed with error: %s", err)
+		return err
 	}
 
-	decode := json.NewDecoder(resp.Body)
-	var exchangeRateResponse ExchangeRateAPIResponse
-	err = decode.Decode(&exchangeRateResponse)
-	if err != nil {
-		return 0, fmt.Errorf("wrong format from exchange rates API, error: %s", err)
-	}
-
-	rate := 1.0
-	switch currency {
-	case "USD":
-		rate = exchangeRateResponse.Rates.USD
-	case "BGN":
-		rate = exchangeRateResponse.Rates.BGN
-	case "EUR":
-		rate = 1.0
-	case "GBP":
-		rate = exchangeRateResponse.Rates.GBP
-	case "CAD":
-		rate = exchangeRateResponse.Rates.CAD
-	case "CHF":
-		rate = exchangeRateResponse.Rates.CHF
-	default:
-		return 0, fmt.Errorf("unsupported currency")
+	var coupon *Coupon
+	if order.CouponCode != "" {
+		if coupon, err = repos.Coupons.GetCouponByCode(order.CouponCode); err != nil {
+			return err
+		}
+	}
+
+	if err = priceOrder(order, lines, promotions, coupon); err != nil {
+		return err
+	}
+
+	if err = repos.Orders.DeleteOrderDiscounts(order.ID); err != nil {
+		return err
+	}
+
+	return addOrderDiscounts(repos, order.ID, order.Discounts)
+}
+
+func addOrderDiscounts(repos database.Repositories, orderId string, discounts []OrderDiscount) error {
+	for i := range discounts {
+		discounts[i].OrderId = orderId
+		if err := repos.Orders.AddOrderDiscount(&discounts[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateOrder stores the contact details of the order and, when products are
+// given, makes them the products of the order. Stock follows the change in
+// quantity of every product and the total is computed again with the
+// promotions and coupon the order was placed with, products kept in the order
+// keep the price they were ordered at. Prices and coupons cannot be set by
+// the client. Only Accepted and Paid orders can be updated, later the delivery
+// details are on their way with the products.
+func (s *Service) UpdateOrder(order *Order) error {
+	if order.Price != (Money{}) || order.Subtotal != (Money{}) || order.Discounts != nil {
+		return fmt.Errorf("invalid order: the price of an order is computed by the shop")
+	}
+	if order.CouponCode != "" {
+		return fmt.Errorf("invalid order: a coupon can only be given when the order is placed")
+	}
+	for _, p := range order.Products {
+		if p.Price != (Money{}) {
+			return fmt.Errorf("invalid order: the price of product %s is set by the shop", p.ID)
+		}
+	}
+
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		stored, err := repos.Orders.GetOrderById(order.ID)
+		if err != nil {
+			return err
+		}
+
+		if stored.Status != StatusAccepted && stored.Status != StatusPaid {
+			return fmt.Errorf("order %s is %s and can no longer be updated", stored.ID, stored.Status)
+		}
+
+		order.Price = stored.Price
+		order.Subtotal = stored.Subtotal
+		order.Discounts = stored.Discounts
+		order.CouponCode = stored.CouponCode
+		if order.Products == nil {
+			order.Products = stored.Products
+			return repos.Orders.UpdateOrder(order)
+		}
+
+		lines, err := changeOrderedProducts(repos, stored, order.Products)
+		if err != nil {
+			return err
+		}
+
+		if err = repriceOrder(repos, order, lines); err != nil {
+			return err
+		}
+
+		return repos.Orders.UpdateOrder(order)
+	})
+}
+
+// changeOrderedProducts turns the products of the stored order into the
+// requested ones and returns the new lines of the order. Only the products
+// whose quantity changed are touched, and only while the order is still
+// Accepted.
+func changeOrderedProducts(repos database.Repositories, stored *Order, requested []Product) ([]OrderedProduct, error) {
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("invalid order: an order needs at least one product")
+	}
+
+	var ids []string
+	want, have := map[string]int{}, map[string]int{}
+	lines := map[string]OrderedProduct{}
+
+	for _, p := range requested {
+		if p.Quantity <= 0 {
+			return nil, fmt.Errorf("invalid quantity %d of product: %s", p.Quantity, p.ID)
+		}
+		if _, ok := want[p.ID]; !ok {
+			ids = append(ids, p.ID)
+		}
+		want[p.ID] += p.Quantity
+	}
+
+	for _, p := range stored.Products {
+		if _, ok := lines[p.ID]; !ok {
+			lines[p.ID] = OrderedProduct{ProductId: p.ID, Name: p.Name, Category: p.Category, Price: p.Price}
+			if _, ok = want[p.ID]; !ok {
+				ids = append(ids, p.ID)
+			}
+		}
+		have[p.ID] += p.Quantity
+	}
+
+	var changed []string
+	for _, id := range ids {
+		if want[id] != have[id] {
+			changed = append(changed, id)
+		}
+	}
+	if len(changed) > 0 && stored.Status != StatusAccepted {
+		return nil, fmt.Errorf("order %s is %s, its products can no longer change", stored.ID, stored.Status)
+	}
+
+	for _, id := range changed {
+		delta := want[id] - have[id]
+		if delta > 0 {
+			if err := repos.Products.ChangeProductQuantity(id, delta); err != nil {
+				return nil, err
+			}
+		} else if err := repos.Products.RestockProduct(id, -delta); err != nil {
+			return nil, err
+		}
+
+		if have[id] > 0 {
+			if err := repos.Orders.DeleteOrderedProduct(stored.ID, id); err != nil {
+				return nil, err
+			}
+		}
+
+		if want[id] == 0 {
+			continue
+		}
+
+		line, ok := lines[id]
+		if !ok {
+			var err error
+			if line, err = snapshotProduct(repos, id, 0); err != nil {
+				return nil, err
+			}
+		}
+		line.OrderId = stored.ID
+		line.ProductQuantity = want[id]
+		lines[id] = line
+
+		if err := repos.Orders.AddOrderedProduct(&line); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]OrderedProduct, 0, len(want))
+	for _, id := range ids {
+		if want[id] > 0 {
+			line := lines[id]
+			line.ProductQuantity = want[id]
+			result = append(result, line)
+		}
+	}
+
+	return result, nil
+}
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Cancelled and Returned are final.
+var orderTransitions = map[string][]string{
+	StatusAccepted:  {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusPacked, StatusCancelled},
+	StatusPacked:    {StatusShipped, StatusCancelled},
+	StatusShipped:   {StatusDelivered},
+	StatusDelivered: {StatusReturned},
+	StatusCancelled: {},
+	StatusReturned:  {},
+}
+
+func canTransition(from string, to string) bool {
+	for _, status := range orderTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ChangeOrderStatus moves the order to status if its current status allows it.
+func (s *Service) ChangeOrderStatus(orderId string, status string) error {
+	if _, ok := orderTransitions[status]; !ok {
+		return fmt.Errorf("invalid status: %s", status)
+	}
+
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		order, err := repos.Orders.GetOrderById(orderId)
+		if err != nil {
+			return err
+		}
+
+		return transitionOrder(repos, order, status)
+	})
+}
+
+// GetOrderStatusHistory returns the statuses the order went through, oldest first.
+func (s *Service) GetOrderStatusHistory(orderId string) ([]OrderStatusChange, error) {
+	if _, err := s.repos.Orders.GetOrderById(orderId); err != nil {
+		return nil, err
+	}
+
+	return s.repos.Orders.GetOrderStatusHistory(orderId)
+}
+
+// CancelOrder marks the order as cancelled and puts its products back into stock.
+func (s *Service) CancelOrder(orderId string) error {
+	return s.ChangeOrderStatus(orderId, StatusCancelled)
+}
+
+// DeleteOrder removes the order with its products and history. Orders that can
+// still be cancelled are cancelled first, giving their products back to stock
+// and their coupon use back. Shipped and delivered orders handed their products
+// over and cannot be deleted, as their stock could not be given back.
+func (s *Service) DeleteOrder(orderId string) error {
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		order, err := repos.Orders.GetOrderById(orderId)
+		if err != nil {
+			return err
+		}
+
+		if order.Status == StatusShipped || order.Status == StatusDelivered {
+			return fmt.Errorf("order %s is %s and cannot be deleted", orderId, order.Status)
+		}
+
+		if canTransition(order.Status, StatusCancelled) {
+			if err = transitionOrder(repos, order, StatusCancelled); err != nil {
+				return err
+			}
+		}
+
+		if err = repos.Orders.DeleteOrderStatusHistory(orderId); err != nil {
+			return err
+		}
+
+		if err = repos.Orders.DeleteAllProductsForAnOrder(orderId); err != nil {
+			return err
+		}
+
+		if err = repos.Orders.DeleteOrderDiscounts(orderId); err != nil {
+			return err
+		}
+
+		if err = repos.Orders.DeleteOrderPromotions(orderId); err != nil {
+			return err
+		}
+
+		if err = repos.Orders.DeleteOrder(orderId); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// transitionOrder moves the order to status and records the change. Cancelled
+// and returned orders put their products back into stock and give their coupon
+// use back, the only way a use is released. The status is switched before
+// restocking, so an order raced by another change is restocked only once.
+func transitionOrder(repos database.Repositories, order *Order, status string) error {
+	if !canTransition(order.Status, status) {
+		return fmt.Errorf("order %s cannot move from %s to %s", order.ID, order.Status, status)
+	}
+
+	if err := repos.Orders.UpdateOrderStatus(order.ID, order.Status, status); err != nil {
+		return err
+	}
+
+	if err := repos.Orders.AddOrderStatusChange(&OrderStatusChange{OrderId: order.ID, From: order.Status, To: status}); err != nil {
+		return err
+	}
+
+	if status == StatusCancelled || status == StatusReturned {
+		for _, p := range order.Products {
+			if err := repos.Products.RestockProduct(p.ID, p.Quantity); err != nil {
+				return err
+			}
+		}
+
+		if err := releaseCoupon(repos, order.ID); err != nil {
+			return err
+		}
+	}
+
+	order.Status = status
+
+	return nil
+}
+
+func (s *Service) DeleteProduct(productId string) error {
+	if err := s.repos.Products.DeleteProduct(productId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetArchivedProducts returns a page of the deleted products.
+func (s *Service) GetArchivedProducts(query ProductQuery) (*ProductPage, error) {
+	query.Limit = pageLimit(query.Limit)
+	query.Archived = true
+
+	page, err := s.repos.Products.GetAllProducts(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived products with error: %s\n", err)
+	}
+
+	return page, nil
+}
+
+// RestoreProduct puts an archived product back into the catalog.
+func (s *Service) RestoreProduct(productId string) error {
+	if err := s.repos.Products.RestoreProduct(productId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) GetCustomerById(id string) (*Customer, error) {
+	return s.repos.Customers.GetCustomerById(id)
+}
+
+func (s *Service) AddCustomer(customer *Customer) (string, error) {
+	if err := validateCustomer(customer); err != nil {
+		return "", err
+	}
+
+	return s.repos.Customers.AddCustomer(customer)
+}
+
+// UpdateCustomer changes the details of the customer. Orders placed before
+// keep the delivery details they were placed with.
+func (s *Service) UpdateCustomer(customer *Customer) error {
+	if err := validateCustomer(customer); err != nil {
+		return err
+	}
+
+	return s.repos.Customers.UpdateCustomer(customer)
+}
+
+// GetCustomerOrders returns a page of the orders of the customer.
+func (s *Service) GetCustomerOrders(customerId string, query OrderQuery, currency string) (*OrderPage, error) {
+	if _, err := s.repos.Customers.GetCustomerById(customerId); err != nil {
+		return nil, err
+	}
+
+	query.CustomerId = customerId
+
+	return s.GetAllOrders(query, currency)
+}
+
+func validateCustomer(customer *Customer) error {
+	if customer.Name == "" || customer.Address == "" {
+		return fmt.Errorf("invalid customer: name and address are required")
+	}
+
+	return nil
+}
+
+// CreateCart starts an empty cart, of the customer when customerId is set.
+func (s *Service) CreateCart(customerId string) (string, error) {
+	if customerId != "" {
+		if _, err := s.repos.Customers.GetCustomerById(customerId); err != nil {
+			return "", err
+		}
+	}
+
+	return s.repos.Carts.AddCart(&Cart{CustomerId: customerId})
+}
+
+// GetCart returns the cart with the current price and stock of its products.
+// The total is left empty while the products are priced in different
+// currencies, such a cart cannot be checked out.
+func (s *Service) GetCart(cartId string) (*Cart, error) {
+	cart, err := s.repos.Carts.GetCartById(cartId)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, err := s.repos.Reservations.GetReservationsForCart(cartId)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := map[string]int{}
+	for _, res := range reservations {
+		reserved[res.ProductId] += res.Quantity
+		if cart.ReservedUntil == nil || res.ExpiresAt.Before(*cart.ReservedUntil) {
+			expiresAt := res.ExpiresAt
+			cart.ReservedUntil = &expiresAt
+		}
+	}
+
+	lines := make([]OrderedProduct, 0, len(cart.Items))
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		item.Reserved = reserved[item.ProductId]
+
+		product, err := s.repos.Products.GetProductById(item.ProductId)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Name = product.Name
+		item.Category = product.Category
+		item.Price = product.Price
+		if product.DeletedAt == nil {
+			item.Available = product.Quantity - product.Reserved + item.Reserved
+		}
+		item.InStock = item.Available >= item.Quantity
+
+		lines = append(lines, OrderedProduct{ProductQuantity: item.Quantity, Price: product.Price})
+	}
+
+	if total, err := orderTotal(lines); err == nil {
+		cart.Total = total
+	}
+
+	return cart, nil
+}
+
+// SetCartItem puts the quantity of the product into the cart, replacing the
+// quantity already in it. Stock is checked on reservation and checkout only.
+// Changing the products of a cart releases the stock reserved for it.
+func (s *Service) SetCartItem(cartId string, productId string, quantity int) error {
+	if quantity <= 0 {
+		return fmt.Errorf("invalid quantity %d of product: %s", quantity, productId)
+	}
+
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		cart, err := openCart(repos, cartId)
+		if err != nil {
+			return err
+		}
+
+		if err = releaseCart(repos, cartId); err != nil {
+			return err
+		}
+
+		product, err := repos.Products.GetProductById(productId)
+		if err != nil {
+			return err
+		}
+		if product.DeletedAt != nil {
+			return fmt.Errorf("archived product: %s", productId)
+		}
+
+		for _, item := range cart.Items {
+			if item.ProductId == productId {
+				return repos.Carts.UpdateCartItem(cartId, productId, quantity)
+			}
+		}
+
+		return repos.Carts.AddCartItem(cartId, &CartItem{ProductId: productId, Quantity: quantity})
+	})
+}
+
+func (s *Service) RemoveCartItem(cartId string, productId string) error {
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		if _, err := openCart(repos, cartId); err != nil {
+			return err
+		}
+
+		if err := releaseCart(repos, cartId); err != nil {
+			return err
+		}
+
+		return repos.Carts.DeleteCartItem(cartId, productId)
+	})
+}
+
+// Checkout places an order with the products of the cart the same way
+// AddOrder does, using the stock reserved for the cart. Delivery details
+// missing from delivery are taken from the customer of the cart.
+func (s *Service) Checkout(cartId string, delivery *Order) (string, error) {
+	var orderId string
+
+	err := s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		cart, err := openCart(repos, cartId)
+		if err != nil {
+			return err
+		}
+
+		if len(cart.Items) == 0 {
+			return fmt.Errorf("invalid cart: it is empty")
+		}
+
+		if err = releaseCart(repos, cartId); err != nil {
+			return err
+		}
+
+		order := Order{CustomerId: cart.CustomerId, Name: delivery.Name, Address: delivery.Address, Phone: delivery.Phone, CouponCode: delivery.CouponCode}
+		for _, item := range cart.Items {
+			order.Products = append(order.Products, Product{ID: item.ProductId, Quantity: item.Quantity})
+		}
+
+		if orderId, err = placeOrder(repos, &order); err != nil {
+			return err
+		}
+
+		return repos.Carts.CheckOutCart(cartId, orderId)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return orderId, nil
+}
+
+// AddCoupon creates a discount code. Codes are kept in upper case and are
+// matched regardless of case.
+func (s *Service) AddCoupon(coupon *Coupon) (string, error) {
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	if coupon.Code == "" || strings.ContainsAny(coupon.Code, " \t") {
+		return "", fmt.Errorf("invalid coupon: code %q", coupon.Code)
+	}
+
+	switch coupon.Kind {
+	case CouponPercentage, CouponCategory:
+		if coupon.Percent < 1 || coupon.Percent > 100 {
+			return "", fmt.Errorf("invalid coupon: percent has to be between 1 and 100, got %d", coupon.Percent)
+		}
+		if coupon.Kind == CouponCategory && coupon.Category == "" {
+			return "", fmt.Errorf("invalid coupon: category is required")
+		}
+	case CouponFixed:
+		if err := validatePrice(&coupon.Amount); err != nil {
+			return "", fmt.Errorf("invalid coupon: %s", err)
+		}
+		if coupon.Amount.Amount == 0 {
+			return "", fmt.Errorf("invalid coupon: amount is required")
+		}
+	case CouponFreeItem:
+		if _, err := s.repos.Products.GetProductById(coupon.ProductId); err != nil {
+			return "", fmt.Errorf("invalid coupon: %s", err)
+		}
+	default:
+		return "", fmt.Errorf("invalid coupon: unknown kind %s", coupon.Kind)
+	}
+
+	if coupon.ValidFrom != nil && coupon.ValidUntil != nil && !coupon.ValidFrom.Before(*coupon.ValidUntil) {
+		return "", fmt.Errorf("invalid coupon: valid_from has to be before valid_until")
+	}
+
+	if coupon.MaxUses < 0 || coupon.MaxUsesPerCustomer < 0 {
+		return "", fmt.Errorf("invalid coupon: limits cannot be negative")
+	}
+
+	if _, err := s.repos.Coupons.GetCouponByCode(coupon.Code); err == nil {
+		return "", fmt.Errorf("coupon with code %s already exists", coupon.Code)
+	}
+
+	return s.repos.Coupons.AddCoupon(coupon)
+}
+
+func (s *Service) GetAllCoupons() ([]Coupon, error) {
+	return s.repos.Coupons.GetAllCoupons()
+}
+
+// AddPromotion stores a promotion, it applies to orders placed from then on
+// while it is active and valid.
+func (s *Service) AddPromotion(promotion *Promotion) (string, error) {
+	if err := validatePromotion(promotion); err != nil {
+		return "", err
+	}
+
+	return s.repos.Promotions.AddPromotion(promotion)
+}
+
+// UpdatePromotion changes a promotion for the orders placed from then on.
+// Orders placed before keep the promotion as it was, also when they are
+// updated.
+func (s *Service) UpdatePromotion(promotion *Promotion) error {
+	if err := validatePromotion(promotion); err != nil {
+		return err
+	}
+
+	return s.repos.Promotions.UpdatePromotion(promotion)
+}
+
+func (s *Service) GetAllPromotions() ([]Promotion, error) {
+	return s.repos.Promotions.GetAllPromotions()
+}
+
+func validatePromotion(promotion *Promotion) error {
+	if strings.TrimSpace(promotion.Name) == "" {
+		return fmt.Errorf("invalid promotion: name is required")
+	}
+
+	rule := promotion.Rule
+	switch rule.Kind {
+	case PromotionBuyXGetY:
+		if rule.Buy < 2 || rule.Free < 1 || rule.Free >= rule.Buy {
+			return fmt.Errorf("invalid promotion: free has to be at least 1 and less than buy, got buy %d and free %d", rule.Buy, rule.Free)
+		}
+	case PromotionVolumeTiers:
+		if len(rule.Tiers) == 0 {
+			return fmt.Errorf("invalid promotion: at least one tier is required")
+		}
+		for _, tier := range rule.Tiers {
+			if tier.MinQuantity < 1 || tier.Percent < 1 || tier.Percent > 100 {
+				return fmt.Errorf("invalid promotion: tiers need a min_quantity of at least 1 and a percent between 1 and 100")
+			}
+		}
+	default:
+		return fmt.Errorf("invalid promotion: unknown kind %s", rule.Kind)
+	}
+
+	if promotion.ValidFrom != nil && promotion.ValidUntil != nil && !promotion.ValidFrom.Before(*promotion.ValidUntil) {
+		return fmt.Errorf("invalid promotion: valid_from has to be before valid_until")
+	}
+
+	return nil
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// reservationLifetime is how long stock stays reserved for a cart.
+const reservationLifetime = 15 * time.Minute
+
+// expiryBatch is how many expired reservations are released per transaction.
+const expiryBatch = 100
+
+// ReserveCart holds the stock of the products in the cart for
+// reservationLifetime, in place of what was reserved for it before. Either
+// every product is reserved or none.
+func (s *Service) ReserveCart(cartId string) (*Cart, error) {
+	err := s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		cart, err := openCart(repos, cartId)
+		if err != nil {
+			return err
+		}
+
+		if len(cart.Items) == 0 {
+			return fmt.Errorf("invalid cart: it is empty")
+		}
+
+		if err = releaseCart(repos, cartId); err != nil {
+			return err
+		}
+
+		expiresAt := time.Now().UTC().Add(reservationLifetime)
+		for _, item := range cart.Items {
+			if err = repos.Products.ReserveProduct(item.ProductId, item.Quantity); err != nil {
+				return err
+			}
+
+			res := Reservation{CartId: cartId, ProductId: item.ProductId, Quantity: item.Quantity, ExpiresAt: expiresAt}
+			if err = repos.Reservations.AddReservation(&res); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(cartId)
+}
+
+// ReleaseCart gives back the stock reserved for the cart.
+func (s *Service) ReleaseCart(cartId string) error {
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		if _, err := openCart(repos, cartId); err != nil {
+			return err
+		}
+
+		return releaseCart(repos, cartId)
+	})
+}
+
+// ExpireReservations gives back the stock of every reservation that expired
+// and returns how many reservations it released.
+func (s *Service) ExpireReservations() (int, error) {
+	released := 0
+	for {
+		var expired []Reservation
+
+		err := s.transactor.WithinTransaction(func(repos database.Repositories) error {
+			var err error
+			if expired, err = repos.Reservations.GetExpiredReservations(time.Now().UTC(), expiryBatch); err != nil {
+				return err
+			}
+
+			for _, res := range expired {
+				if err = releaseReservation(repos, res); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return released, err
+		}
+
+		released += len(expired)
+		if len(expired) < expiryBatch {
+			return released, nil
+		}
+	}
+}
+
+// ExpireReservationsEvery runs ExpireReservations every interval until stop
+// is closed. Failures are logged and tried again on the next run.
+func (s *Service) ExpireReservationsEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			released, err := s.ExpireReservations()
+			if err != nil {
+				log.Printf("expiring reservations failed with: %s", err)
+			} else if released > 0 {
+				log.Printf("released %d expired reservations", released)
+			}
+		}
+	}
+}
+
+// releaseCart gives back the stock reserved for the cart.
+func releaseCart(repos database.Repositories, cartId string) error {
+	reservations, err := repos.Reservations.GetReservationsForCart(cartId)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range reservations {
+		if err = releaseReservation(repos, res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseReservation deletes the reservation and gives back its stock. A
+// reservation someone else released meanwhile is skipped.
+func releaseReservation(repos database.Repositories, res Reservation) error {
+	if err := repos.Reservations.DeleteReservation(res.ID); err != nil {
+		if strings.HasPrefix(err.Error(), "no reservation") {
+			return nil
+		}
+		return err
+	}
+
+	return repos.Products.ReleaseProduct(res.ProductId, res.Quantity)
+}
+
+// openCart reads a cart that can still be changed.
+func openCart(repos database.Repositories, cartId string) (*Cart, error) {
+	cart, err := repos.Carts.GetCartById(cartId)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart.OrderId != "" {
+		return nil, fmt.Errorf("cart %s is already checked out", cartId)
+	}
+
+	return cart, nil
+}
+
+// minPasswordLength is the shortest password accepted for an account.
+const minPasswordLength = 8
+
+// Login returns the user with the email when the password matches.
+func (s *Service) Login(email string, password string) (*User, error) {
+	user, err := s.repos.Users.GetUserByEmail(normalizeEmail(email))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no user") {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return nil, err
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	return user, nil
+}
+
+// AuthenticateToken checks that a token issued to the user at the token
+// version is still valid, which it is not after the password was changed.
+func (s *Service) AuthenticateToken(userId string, version int) error {
+	user, err := s.repos.Users.GetUserById(userId)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no user") {
+			return fmt.Errorf("invalid token: unknown user")
+		}
+		return err
+	}
+
+	if user.TokenVersion != version {
+		return fmt.Errorf("invalid token: the password was changed")
+	}
+
+	return nil
+}
+
+// AddUser creates an account with the password. Customer accounts have to
+// belong to an existing customer, the other roles to none.
+func (s *Service) AddUser(user *User, password string) (string, error) {
+	return addUser(s.repos, user, password)
+}
+
+func addUser(repos database.Repositories, user *User, password string) (string, error) {
+	user.Email = normalizeEmail(user.Email)
+	if !strings.Contains(user.Email, "@") {
+		return "", fmt.Errorf("invalid user: email %s", user.Email)
+	}
+
+	switch user.Role {
+	case RoleAdmin, RoleStaff:
+		if user.CustomerId != "" {
+			return "", fmt.Errorf("invalid user: only customer accounts belong to a customer")
+		}
+	case RoleCustomer:
+		if _, err := repos.Customers.GetCustomerById(user.CustomerId); err != nil {
+			return "", fmt.Errorf("invalid user: %s", err)
+		}
+	default:
+		return "", fmt.Errorf("invalid user: unknown role %s", user.Role)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	user.PasswordHash = hash
+
+	if _, err = repos.Users.GetUserByEmail(user.Email); err == nil {
+		return "", fmt.Errorf("user with email %s already exists", user.Email)
+	}
+
+	return repos.Users.AddUser(user)
+}
+
+// Register creates a customer together with the account the customer signs
+// in with.
+func (s *Service) Register(customer *Customer, email string, password string) (*User, error) {
+	if err := validateCustomer(customer); err != nil {
+		return nil, err
+	}
+
+	user := User{Email: email, Role: RoleCustomer}
+	err := s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		customerId, err := repos.Customers.AddCustomer(customer)
+		if err != nil {
+			return err
+		}
+		customer.ID = customerId
+		user.CustomerId = customerId
+
+		user.ID, err = addUser(repos, &user, password)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ChangePassword replaces the password of the user after checking the current one.
+func (s *Service) ChangePassword(userId string, current string, password string) error {
+	user, err := s.repos.Users.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)); err != nil {
+		return fmt.Errorf("invalid credentials")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.repos.Users.UpdateUserPassword(userId, hash)
+}
+
+// passwordResetLifetime is how long a password reset token can be used.
+const passwordResetLifetime = time.Hour
+
+// RequestPasswordReset sends a token to the user with the email that lets them
+// set a new password. Nothing is sent for unknown emails, and the caller is not
+// told about it.
+func (s *Service) RequestPasswordReset(email string) error {
+	user, err := s.repos.Users.GetUserByEmail(normalizeEmail(email))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no user") {
+			return nil
+		}
+		return err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	reset := PasswordReset{UserId: user.ID, TokenHash: hashToken(token), ExpiresAt: time.Now().UTC().Add(passwordResetLifetime)}
+	if err = s.repos.Users.AddPasswordReset(&reset); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use the token %s to set a new password before %s.", token, reset.ExpiresAt.Format(time.RFC1123))
+	if err = s.notifier.Notify(user.Email, "Password reset", body); err != nil {
+		return fmt.Errorf("sending password reset failed with: %s", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset.
+// Every token works once.
+func (s *Service) ResetPassword(token string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTransaction(func(repos database.Repositories) error {
+		reset, err := repos.Users.GetPasswordReset(hashToken(token))
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "no password reset") {
+				return fmt.Errorf("invalid reset token")
+			}
+			return err
+		}
+
+		if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			return fmt.Errorf("invalid reset token: it is used or expired")
+		}
+
+		if err = repos.Users.UsePasswordReset(reset.ID); err != nil {
+			return fmt.Errorf("invalid reset token: %s", err)
+		}
+
+		return repos.Users.UpdateUserPassword(reset.UserId, hash)
+	})
+}
+
+// randomToken returns a new random secret that is safe to put in URLs.
+func randomToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generating token failed with: %s", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashToken is what is stored of tokens handed out to users. The tokens are
+// random, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureAdmin creates an admin account with the email unless an account with
+// it exists, so that a new installation can be signed in to.
+func (s *Service) EnsureAdmin(email string, password string) error {
+	if _, err := s.repos.Users.GetUserByEmail(normalizeEmail(email)); err == nil {
+		return nil
+	}
+
+	_, err := s.AddUser(&User{Email: email, Role: RoleAdmin}, password)
+	return err
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("invalid password: it needs at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hashing password failed with: %s", err)
+	}
+
+	return string(hash), nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to search for.
+const apiKeyPrefix = "shop_"
+
+// IssueAPIKey creates a key with the scopes. The returned secret is not
+// stored and cannot be shown again.
+func (s *Service) IssueAPIKey(name string, scopes []string) (*IssuedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("invalid API key: name is empty")
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("invalid API key: it needs at least one scope")
+	}
+
+	granted := map[string]bool{}
+	key := IssuedAPIKey{APIKey: APIKey{Name: name}}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeCatalogRead, ScopeCatalogWrite, ScopeOrderRead, ScopeOrderWrite, ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("invalid API key: unknown scope %s", scope)
+		}
+
+		if !granted[scope] {
+			granted[scope] = true
+			key.Scopes = append(key.Scopes, scope)
+		}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key.Key = apiKeyPrefix + token
+	key.Prefix = key.Key[:len(apiKeyPrefix)+6]
+	key.KeyHash = hashToken(key.Key)
+
+	if key.ID, err = s.repos.APIKeys.AddAPIKey(&key.APIKey); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *Service) GetAllAPIKeys() ([]APIKey, error) {
+	return s.repos.APIKeys.GetAllAPIKeys()
+}
+
+func (s *Service) RevokeAPIKey(keyId string) error {
+	return s.repos.APIKeys.RevokeAPIKey(keyId)
+}
+
+// apiKeyTouchInterval is how often the last use of an API key is recorded.
+const apiKeyTouchInterval = time.Minute
+
+// AuthenticateAPIKey returns the key with the secret unless it is unknown or
+// revoked, and records that it was used at most once per apiKeyTouchInterval.
+// Failing to record the use does not fail the request.
+func (s *Service) AuthenticateAPIKey(secret string) (*APIKey, error) {
+	key, err := s.repos.APIKeys.GetAPIKeyByHash(hashToken(secret))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "no API key") {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("invalid API key: it is revoked")
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err = s.repos.APIKeys.TouchAPIKey(key.ID); err != nil {
+			log.Printf("recording the use of API key %s failed with: %s", key.ID, err)
+		}
+	}
+
+	return key, nil
+}
+
+// validatePrice puts prices without a currency into DefaultCurrency and
+// rejects negative amounts and currencies the shop cannot convert.
+func validatePrice(price *Money) error {
+	if price.Currency == "" {
+		price.Currency = DefaultCurrency
+	}
+
+	if price.Amount < 0 {
+		return fmt.Errorf("unsupported price: %s", price)
+	}
+
+	switch price.Currency {
+	case "USD", "BGN", "EUR", "GBP", "CAD", "CHF":
+		return nil
+	default:
+		return fmt.Errorf("unsupported currency: %s", price.Currency)
+	}
+}
+
+// convertPrice converts the prices of the order or product into currency with
+// the rates of getRates, it leaves them as they are when currency is empty.
+func convertPrice(object interface{}, currency string, rates map[string]*big.Rat) error {
+	if currency == "" {
+		return nil
+	}
+
+	var err error
+	convert := func(price Money) (Money, error) {
+		from, ok := rates[price.Currency]
+		if !ok {
+			return Money{}, fmt.Errorf("unsupported currency")
+		}
+		to, ok := rates[currency]
+		if !ok {
+			return Money{}, fmt.Errorf("unsupported currency")
+		}
+
+		return price.Convert(new(big.Rat).Quo(to, from), currency), nil
+	}
+
+	switch v := object.(type) {
+	case *Order:
+		{
+			if v.Price, err = convert(v.Price); err != nil {
+				return err
+			}
+			if v.Subtotal, err = convert(v.Subtotal); err != nil {
+				return err
+			}
+			for i := range v.Discounts {
+				if v.Discounts[i].Amount, err = convert(v.Discounts[i].Amount); err != nil {
+					return err
+				}
+			}
+			for i := range v.Products {
+				if v.Products[i].Price, err = convert(v.Products[i].Price); err != nil {
+					return err
+				}
+			}
+		}
+	case *Product:
+		{
+			if v.Price, err = convert(v.Price); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type")
+	}
+
+	return nil
+}
+
+// ratesFor returns the exchange rates needed to show prices in currency, none
+// when they are shown as stored. A request fetches the rates once, whatever
+// the number of prices it converts.
+func ratesFor(currency string) (map[string]*big.Rat, error) {
+	if currency == "" {
+		return nil, nil
+	}
+
+	return getRates()
+}
+
+// exchangeRatesURL is where getRates fetches the latest exchange rates from.
+var exchangeRatesURL = "http://api.exchangeratesapi.io/v1/latest?access_key=a3d5d57407a65c0b4fa4853c2e5cbe07&format=1"
+
+// getRates returns what one euro is worth in each supported currency. The
+// rates are kept as exact decimals, as they were sent by the API.
+func getRates() (map[string]*big.Rat, error) {
+	resp, err := http.Get(exchangeRatesURL)
+	if err != nil {
+		return nil, fmt.Errorf("request to exchange rates API failed with error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	decode := json.NewDecoder(resp.Body)
+	var exchangeRateResponse ExchangeRateAPIResponse
+	err = decode.Decode(&exchangeRateResponse)
+	if err != nil {
+		return nil, fmt.Errorf("wrong format from exchange rates API, error: %s", err)
+	}
+
+	rates := map[string]*big.Rat{"EUR": big.NewRat(1, 1)}
+	for currency, value := range map[string]json.Number{
+		"USD": exchangeRateResponse.Rates.USD,
+		"BGN": exchangeRateResponse.Rates.BGN,
+		"GBP": exchangeRateResponse.Rates.GBP,
+		"CAD": exchangeRateResponse.Rates.CAD,
+		"CHF": exchangeRateResponse.Rates.CHF,
+	} {
+		rate, ok := new(big.Rat).SetString(value.String())
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("wrong format from exchange rates API, rate of %s: %s", currency, value)
+		}
+		rates[currency] = rate
 	}
 
-	return rate, nil
+	return rates, nil
 }
@@ -0,0 +1,840 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/golang-rest-shop-backend/pkg/database"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestService returns a service on a fresh SQLite database.
+func newTestService(t testing.TB) (*Service, *database.SqlRepository) {
+	t.Helper()
+
+	os.Setenv("DB_DRIVER", "sqlite")
+	os.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "shop.sqlite"))
+
+	repository, err := database.InitConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewService(repository.Repositories(), repository, LogNotifier{}), repository
+}
+
+func TestConcurrentOrdersNeverOversell(t *testing.T) {
+	s, _ := newTestService(t)
+
+	const stock, orders = 10, 50
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: stock, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var placed int32
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: productId, Quantity: 1}}})
+			if err == nil {
+				atomic.AddInt32(&placed, 1)
+			} else if !strings.HasPrefix(err.Error(), "not enough quantity") {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	product, err := s.GetProductById(productId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Quantity < 0 {
+		t.Fatalf("stock went negative: %d", product.Quantity)
+	}
+	if placed != stock || product.Quantity != 0 {
+		t.Fatalf("expected %d orders to sell out the stock, got %d orders and %d left", stock, placed, product.Quantity)
+	}
+}
+
+func TestCouponLimitPerCustomerHoldsUnderConcurrentOrders(t *testing.T) {
+	s, repository := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 100, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerId, err := s.AddCustomer(&Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddCoupon(&Coupon{Code: "TWICE", Kind: CouponPercentage, Percent: 10, MaxUsesPerCustomer: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	const orders = 10
+	ids := make(chan string, orders)
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id, err := s.AddOrder(&Order{CustomerId: customerId, CouponCode: "TWICE", Products: []Product{{ID: productId, Quantity: 1}}})
+			if err == nil {
+				ids <- id
+			} else if !strings.HasPrefix(err.Error(), "invalid coupon") {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	var placed []string
+	for id := range ids {
+		placed = append(placed, id)
+	}
+	if len(placed) != 2 {
+		t.Fatalf("expected 2 orders with the coupon, got %d", len(placed))
+	}
+
+	// Cancelling one gives its use back, deleting the other as well.
+	if err = s.CancelOrder(placed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repository.GetCouponRedemptionForOrder(placed[0]); err == nil {
+		t.Fatal("the redemption of the cancelled order was kept")
+	}
+	if err = s.DeleteOrder(placed[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = s.AddOrder(&Order{CustomerId: customerId, CouponCode: "TWICE", Products: []Product{{ID: productId, Quantity: 1}}}); err != nil {
+			t.Fatalf("use %d given back was not redeemable: %s", i+1, err)
+		}
+	}
+	if _, err = s.AddOrder(&Order{CustomerId: customerId, CouponCode: "TWICE", Products: []Product{{ID: productId, Quantity: 1}}}); err == nil {
+		t.Fatal("the coupon was redeemed over its limit")
+	}
+
+	coupon, err := repository.GetCouponByCode("TWICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coupon.Uses != 2 {
+		t.Fatalf("expected 2 uses of the coupon, got %d", coupon.Uses)
+	}
+}
+
+// serveRates answers requests for exchange rates with one dollar at 1.1 euro
+// and returns the number of requests answered.
+func serveRates(t *testing.T) *int32 {
+	var requests int32
+	rates := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"success": true, "base": "EUR", "rates": {"BGN": 1.95583, "CAD": 1.4, "CHF": 0.95, "GBP": 0.85, "USD": 1.1}}`)
+	}))
+	t.Cleanup(rates.Close)
+
+	url := exchangeRatesURL
+	exchangeRatesURL = rates.URL
+	t.Cleanup(func() { exchangeRatesURL = url })
+
+	return &requests
+}
+
+func TestGetOrderByIdInAnotherCurrency(t *testing.T) {
+	s, _ := newTestService(t)
+	serveRates(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000, Currency: "EUR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderId, err := s.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: productId, Quantity: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := s.GetOrderById(orderId, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Price != (Money{Amount: 2200, Currency: "USD"}) {
+		t.Fatalf("expected the order to cost 22.00 USD, got %+v", order.Price)
+	}
+	if order.Products[0].Price != (Money{Amount: 1100, Currency: "USD"}) {
+		t.Fatalf("expected the product to cost 11.00 USD, got %+v", order.Products[0].Price)
+	}
+}
+
+func TestUpdateOrderKeepsPromotionsOfItsTime(t *testing.T) {
+	s, _ := newTestService(t)
+
+	shirtId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 50, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hatId, err := s.AddProduct(&Product{Name: "Hat", Category: "Hats", Quantity: 50, Price: Money{Amount: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threeForTwo := Promotion{Name: "3 for 2", Active: true, Rule: PromotionRule{Kind: PromotionBuyXGetY, Category: "Men Shirts", Buy: 3, Free: 1}}
+	if threeForTwo.ID, err = s.AddPromotion(&threeForTwo); err != nil {
+		t.Fatal(err)
+	}
+	hats := Promotion{Name: "Hats", Active: true, Rule: PromotionRule{Kind: PromotionVolumeTiers, ProductId: hatId, Tiers: []PromotionTier{{MinQuantity: 5, Percent: 10}}}}
+	if hats.ID, err = s.AddPromotion(&hats); err != nil {
+		t.Fatal(err)
+	}
+
+	orderId, err := s.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: shirtId, Quantity: 3}, {ID: hatId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Promotions change after the order was placed.
+	threeForTwo.Rule.Buy, threeForTwo.Rule.Free = 2, 1
+	if err = s.UpdatePromotion(&threeForTwo); err != nil {
+		t.Fatal(err)
+	}
+	hats.Active = false
+	if err = s.UpdatePromotion(&hats); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddPromotion(&Promotion{Name: "Later", Active: true, Rule: PromotionRule{Kind: PromotionVolumeTiers, Tiers: []PromotionTier{{MinQuantity: 1, Percent: 50}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := s.GetOrderById(orderId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.Price, order.Subtotal, order.Discounts, order.CouponCode = Money{}, Money{}, nil, ""
+	order.Products = []Product{{ID: shirtId, Quantity: 3}, {ID: hatId, Quantity: 5}}
+	if err = s.UpdateOrder(order); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 shirts for 2 as the promotion was, and 10% off the 5 hats now that
+	// they qualify.
+	order, err = s.GetOrderById(orderId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Discounts) != 2 || order.Discounts[0].Amount.Amount != 1000 || order.Discounts[1].Amount.Amount != 250 {
+		t.Fatalf("expected discounts of 1000 and 250, got %+v", order.Discounts)
+	}
+	if order.Subtotal.Amount != 5500 || order.Price.Amount != 4250 {
+		t.Fatalf("expected 55.00 less 12.50, got %d and %d", order.Subtotal.Amount, order.Price.Amount)
+	}
+}
+
+func TestReturnedOrderGivesCouponUseBack(t *testing.T) {
+	s, repository := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerId, err := s.AddCustomer(&Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddCoupon(&Coupon{Code: "ONCE", Kind: CouponPercentage, Percent: 10, MaxUsesPerCustomer: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	orderId, err := s.AddOrder(&Order{CustomerId: customerId, CouponCode: "ONCE", Products: []Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{StatusPaid, StatusPacked, StatusShipped, StatusDelivered, StatusReturned} {
+		if err = s.ChangeOrderStatus(orderId, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting the returned order does not give the use back a second time.
+	if err = s.DeleteOrder(orderId); err != nil {
+		t.Fatal(err)
+	}
+	coupon, err := repository.GetCouponByCode("ONCE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coupon.Uses != 0 {
+		t.Fatalf("expected no uses of the coupon, got %d", coupon.Uses)
+	}
+
+	if _, err = s.AddOrder(&Order{CustomerId: customerId, CouponCode: "ONCE", Products: []Product{{ID: productId, Quantity: 1}}}); err != nil {
+		t.Fatalf("the use of the returned order was not given back: %s", err)
+	}
+}
+
+func TestUpdateOrderOnlyBeforePacking(t *testing.T) {
+	s, _ := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 50, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		statuses []string
+		updated  bool
+	}{
+		{nil, true},
+		{[]string{StatusPaid}, true},
+		{[]string{StatusPaid, StatusPacked}, false},
+		{[]string{StatusPaid, StatusPacked, StatusShipped}, false},
+		{[]string{StatusPaid, StatusPacked, StatusShipped, StatusDelivered}, false},
+		{[]string{StatusPaid, StatusPacked, StatusShipped, StatusDelivered, StatusReturned}, false},
+		{[]string{StatusCancelled}, false},
+	} {
+		orderId, err := s.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: productId, Quantity: 1}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range tc.statuses {
+			if err = s.ChangeOrderStatus(orderId, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		order, err := s.GetOrderById(orderId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.UpdateOrder(&Order{ID: orderId, Name: "Petar", Address: "Plovdiv", Phone: "0999", Version: order.Version})
+		if tc.updated && err != nil {
+			t.Fatalf("updating a %s order failed: %s", order.Status, err)
+		}
+		if !tc.updated && (err == nil || !strings.HasPrefix(err.Error(), "order ")) {
+			t.Fatalf("expected a %s order not to be updated, got %v", order.Status, err)
+		}
+
+		order, err = s.GetOrderById(orderId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (order.Address == "Plovdiv") != tc.updated {
+			t.Fatalf("the %s order has address %s", order.Status, order.Address)
+		}
+	}
+}
+
+func TestGetAllProductsFetchesRatesOnce(t *testing.T) {
+	s, _ := newTestService(t)
+	requests := serveRates(t)
+
+	for i := 0; i < 5; i++ {
+		if _, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := s.GetAllProducts(ProductQuery{}, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range page.Products {
+		if p.Price != (Money{Amount: 1100, Currency: "USD"}) {
+			t.Fatalf("expected the product to cost 11.00 USD, got %+v", p.Price)
+		}
+	}
+	if *requests != 1 {
+		t.Fatalf("expected the rates to be fetched once for %d products, got %d", len(page.Products), *requests)
+	}
+}
+
+// failingTouch is an API key store that cannot record the use of keys.
+type failingTouch struct {
+	database.APIKeyRepository
+	touches int
+}
+
+func (r *failingTouch) TouchAPIKey(keyId string) error {
+	r.touches++
+	return fmt.Errorf("database is locked")
+}
+
+func TestAuthenticateAPIKeyRecordsUseOncePerMinute(t *testing.T) {
+	s, repository := newTestService(t)
+
+	key, err := s.IssueAPIKey("warehouse", []string{ScopeOrderRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last *time.Time
+	for i := 0; i < 3; i++ {
+		used, err := s.AuthenticateAPIKey(key.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !used.LastUsedAt.Equal(*last) {
+			t.Fatalf("use %d of the key was recorded again", i+1)
+		}
+
+		stored, err := repository.GetAPIKeyByHash(hashToken(key.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.LastUsedAt == nil {
+			t.Fatal("the use of the key was not recorded")
+		}
+		last = stored.LastUsedAt
+	}
+
+	// A store that cannot record the use still lets the key in.
+	repos := repository.Repositories()
+	touch := &failingTouch{APIKeyRepository: repos.APIKeys}
+	repos.APIKeys = touch
+	s = NewService(repos, repository, LogNotifier{})
+
+	other, err := s.IssueAPIKey("billing", []string{ScopeOrderRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AuthenticateAPIKey(other.Key); err != nil {
+		t.Fatalf("a valid key was refused when its use could not be recorded: %s", err)
+	}
+	if touch.touches != 1 {
+		t.Fatalf("expected one attempt to record the use, got %d", touch.touches)
+	}
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	s, _ := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 1000, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := map[string][]string{
+		StatusAccepted:  nil,
+		StatusPaid:      {StatusPaid},
+		StatusPacked:    {StatusPaid, StatusPacked},
+		StatusShipped:   {StatusPaid, StatusPacked, StatusShipped},
+		StatusDelivered: {StatusPaid, StatusPacked, StatusShipped, StatusDelivered},
+		StatusReturned:  {StatusPaid, StatusPacked, StatusShipped, StatusDelivered, StatusReturned},
+		StatusCancelled: {StatusCancelled},
+	}
+	allowed := map[string]map[string]bool{
+		StatusAccepted:  {StatusPaid: true, StatusCancelled: true},
+		StatusPaid:      {StatusPacked: true, StatusCancelled: true},
+		StatusPacked:    {StatusShipped: true, StatusCancelled: true},
+		StatusShipped:   {StatusDelivered: true},
+		StatusDelivered: {StatusReturned: true},
+	}
+	quantity := func() int {
+		product, err := s.GetProductById(productId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return product.Quantity
+	}
+
+	for from, path := range paths {
+		for to := range paths {
+			orderId, err := s.AddOrder(&Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: productId, Quantity: 1}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, status := range path {
+				if err = s.ChangeOrderStatus(orderId, status); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := quantity()
+			err = s.ChangeOrderStatus(orderId, to)
+			if allowed[from][to] != (err == nil) {
+				t.Fatalf("moving an order from %s to %s: %v", from, to, err)
+			}
+			if err != nil && !strings.HasPrefix(err.Error(), "order ") {
+				t.Fatalf("moving an order from %s to %s failed with: %s", from, to, err)
+			}
+
+			restocked := 0
+			if err == nil && (to == StatusCancelled || to == StatusReturned) {
+				restocked = 1
+			}
+			if quantity()-before != restocked {
+				t.Fatalf("moving an order from %s to %s changed the stock by %d", from, to, quantity()-before)
+			}
+
+			history, err := s.GetOrderStatusHistory(orderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append([]string{StatusAccepted}, path...)
+			if allowed[from][to] {
+				want = append(want, to)
+			}
+			if len(history) != len(want) || history[len(history)-1].To != want[len(want)-1] {
+				t.Fatalf("moving an order from %s to %s left the history %+v", from, to, history)
+			}
+		}
+	}
+
+	if err = s.ChangeOrderStatus("no-such-order", StatusPaid); err == nil || !strings.HasPrefix(err.Error(), "no order") {
+		t.Fatalf("expected an unknown order not to be found, got %v", err)
+	}
+	if err = s.ChangeOrderStatus("no-such-order", "Lost"); err == nil || !strings.HasPrefix(err.Error(), "invalid status") {
+		t.Fatalf("expected an unknown status to be invalid, got %v", err)
+	}
+}
+
+func TestCustomerOrdersKeepTheirDeliveryDetails(t *testing.T) {
+	s, _ := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer := Customer{Name: "Ivan", Address: "Sofia", Phone: "0888"}
+	if customer.ID, err = s.AddCustomer(&customer); err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := s.AddCustomer(&Customer{Name: "Maria", Address: "Varna", Phone: "0877"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first order takes the details of the customer, the second is sent
+	// to another address.
+	first, err := s.AddOrder(&Order{CustomerId: customer.ID, Products: []Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AddOrder(&Order{CustomerId: customer.ID, Address: "Burgas", Products: []Product{{ID: productId, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.AddOrder(&Order{CustomerId: otherId, Products: []Product{{ID: productId, Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	customer.Address = "Plovdiv"
+	if err = s.UpdateCustomer(&customer); err != nil {
+		t.Fatal(err)
+	}
+
+	for orderId, address := range map[string]string{first: "Sofia", second: "Burgas"} {
+		order, err := s.GetOrderById(orderId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Name != "Ivan" || order.Address != address || order.Phone != "0888" || order.CustomerId != customer.ID {
+			t.Fatalf("expected the order to Ivan in %s, got %+v", address, order)
+		}
+	}
+
+	page, err := s.GetCustomerOrders(customer.ID, OrderQuery{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 {
+		t.Fatalf("expected 2 orders of the customer, got %d", page.Total)
+	}
+
+	if _, err = s.GetCustomerOrders("no-such-customer", OrderQuery{}, ""); err == nil || !strings.HasPrefix(err.Error(), "no customer") {
+		t.Fatalf("expected an unknown customer not to be found, got %v", err)
+	}
+	if _, err = s.AddOrder(&Order{CustomerId: "no-such-customer", Products: []Product{{ID: productId, Quantity: 1}}}); err == nil || !strings.HasPrefix(err.Error(), "no customer") {
+		t.Fatalf("expected an order of an unknown customer to be refused, got %v", err)
+	}
+	if _, err = s.AddCustomer(&Customer{Name: "Nobody"}); err == nil || !strings.HasPrefix(err.Error(), "invalid customer") {
+		t.Fatalf("expected a customer without an address to be invalid, got %v", err)
+	}
+}
+
+func TestReservationsHoldStockUntilCheckoutOrExpiry(t *testing.T) {
+	s, repository := newTestService(t)
+
+	productId, err := s.AddProduct(&Product{Name: "Shirt", Category: "Men Shirts", Quantity: 10, Price: Money{Amount: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stock := func(quantity, reserved int) {
+		t.Helper()
+
+		product, err := s.GetProductById(productId, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if product.Quantity != quantity || product.Reserved != reserved {
+			t.Fatalf("expected %d in stock with %d reserved, got %d with %d", quantity, reserved, product.Quantity, product.Reserved)
+		}
+	}
+
+	cartId, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetCartItem(cartId, productId, 7); err != nil {
+		t.Fatal(err)
+	}
+	cart, err := s.ReserveCart(cartId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.ReservedUntil == nil {
+		t.Fatalf("expected the cart to be reserved, got %+v", cart)
+	}
+	stock(10, 7)
+
+	// Reserving again replaces the reservation instead of adding to it.
+	if _, err = s.ReserveCart(cartId); err != nil {
+		t.Fatal(err)
+	}
+	stock(10, 7)
+
+	order := Order{Name: "Ivan", Address: "Sofia", Phone: "0888", Products: []Product{{ID: productId, Quantity: 4}}}
+	if _, err = s.AddOrder(&order); err == nil {
+		t.Fatal("expected an order for more than the unreserved stock to fail")
+	}
+	order.Products[0].Quantity = 3
+	if _, err = s.AddOrder(&order); err != nil {
+		t.Fatalf("expected an order for the unreserved stock to succeed, got %s", err)
+	}
+	stock(7, 7)
+
+	if _, err = s.Checkout(cartId, &Order{Name: "Maria", Address: "Plovdiv", Phone: "0899"}); err != nil {
+		t.Fatal(err)
+	}
+	stock(0, 0)
+	if reservations, err := repository.GetReservationsForCart(cartId); err != nil || len(reservations) != 0 {
+		t.Fatalf("expected checkout to consume the reservation, got %v (%v)", reservations, err)
+	}
+
+	restocked, err := s.GetProductById(productId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restocked.Quantity = 10
+	if err = s.UpdateProduct(restocked); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reservation the shopper walked away from, and one still running.
+	abandoned, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.ReserveProduct(productId, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.AddReservation(&Reservation{CartId: abandoned, ProductId: productId, Quantity: 2, ExpiresAt: time.Now().UTC().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	running, err := s.CreateCart("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetCartItem(running, productId, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ReserveCart(running); err != nil {
+		t.Fatal(err)
+	}
+	stock(10, 5)
+
+	released, err := s.ExpireReservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 {
+		t.Fatalf("expected 1 expired reservation to be released, got %d", released)
+	}
+	stock(10, 3)
+
+	if err = s.ReleaseCart(running); err != nil {
+		t.Fatal(err)
+	}
+	stock(10, 0)
+}
+
+// pricedLines are 2 shirts of 20.00, a tie of 5.00 and 3 hats of 10.00,
+// 75.00 together.
+var pricedLines = []OrderedProduct{
+	{ProductId: "shirt", Name: "Shirt", Category: "Shirts", ProductQuantity: 2, Price: Money{Amount: 2000, Currency: "EUR"}},
+	{ProductId: "tie", Name: "Tie", Category: "Ties", ProductQuantity: 1, Price: Money{Amount: 500, Currency: "EUR"}},
+	{ProductId: "hat", Name: "Hat", Category: "Hats", ProductQuantity: 3, Price: Money{Amount: 1000, Currency: "EUR"}},
+}
+
+func TestPromotionDiscount(t *testing.T) {
+	tiers := []PromotionTier{{MinQuantity: 2, Percent: 5}, {MinQuantity: 3, Percent: 10}, {MinQuantity: 5, Percent: 20}}
+
+	for _, tc := range []struct {
+		name        string
+		rule        PromotionRule
+		amount      int64
+		description string
+	}{
+		{"cheapest of every 3 free", PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 1}, 1500, "2 of 6 items for free"},
+		{"2 cheapest of every 3 free", PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 2}, 4500, "4 of 6 items for free"},
+		{"buy x get y of a category", PromotionRule{Kind: PromotionBuyXGetY, Category: "Shirts", Buy: 2, Free: 1}, 2000, "1 of 2 items for free"},
+		{"buy x get y of a product", PromotionRule{Kind: PromotionBuyXGetY, ProductId: "hat", Buy: 2, Free: 1}, 1000, "1 of 3 items for free"},
+		{"too few items for buy x get y", PromotionRule{Kind: PromotionBuyXGetY, Category: "Shirts", Buy: 3, Free: 1}, 0, ""},
+		{"highest tier reached", PromotionRule{Kind: PromotionVolumeTiers, Tiers: tiers}, 1500, "20% off 6 items"},
+		{"tier of a category", PromotionRule{Kind: PromotionVolumeTiers, Category: "Hats", Tiers: tiers}, 300, "10% off 3 items"},
+		{"tiers in any order", PromotionRule{Kind: PromotionVolumeTiers, Category: "Hats", Tiers: []PromotionTier{{MinQuantity: 5, Percent: 20}, {MinQuantity: 2, Percent: 5}}}, 150, "5% off 3 items"},
+		{"no tier reached", PromotionRule{Kind: PromotionVolumeTiers, Category: "Ties", Tiers: tiers}, 0, ""},
+		{"nothing matches", PromotionRule{Kind: PromotionVolumeTiers, Category: "Shoes", Tiers: tiers}, 0, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, description := promotionDiscount(tc.rule, pricedLines)
+			if amount.Amount != tc.amount || description != tc.description {
+				t.Fatalf("expected %d for %q, got %d for %q", tc.amount, tc.description, amount.Amount, description)
+			}
+		})
+	}
+}
+
+func TestPriceOrder(t *testing.T) {
+	everyThirdFree := Promotion{ID: "third", Name: "Third for free", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 1}}
+	hatTiers := Promotion{ID: "hats", Name: "Hats in bulk", Rule: PromotionRule{Kind: PromotionVolumeTiers, Category: "Hats", Tiers: []PromotionTier{{MinQuantity: 3, Percent: 10}}}}
+	fifthOff := Promotion{ID: "fifth", Name: "Fifth off", Rule: PromotionRule{Kind: PromotionVolumeTiers, Tiers: []PromotionTier{{MinQuantity: 1, Percent: 20}}}}
+
+	type discount struct {
+		source string
+		code   string
+		amount int64
+	}
+
+	for _, tc := range []struct {
+		name       string
+		promotions []Promotion
+		coupon     *Coupon
+		price      int64
+		discounts  []discount
+		err        string
+	}{
+		{
+			name:  "no discounts",
+			price: 7500,
+		},
+		{
+			name:       "coupon after the promotion",
+			promotions: []Promotion{everyThirdFree},
+			coupon:     &Coupon{Code: "TEN", Kind: CouponPercentage, Percent: 10},
+			price:      5400,
+			discounts:  []discount{{DiscountPromotion, "third", 1500}, {DiscountCoupon, "TEN", 600}},
+		},
+		{
+			name:       "promotions in their order",
+			promotions: []Promotion{hatTiers, everyThirdFree},
+			price:      5700,
+			discounts:  []discount{{DiscountPromotion, "hats", 300}, {DiscountPromotion, "third", 1500}},
+		},
+		{
+			name:       "category coupon on the prices of its products",
+			promotions: []Promotion{fifthOff},
+			coupon:     &Coupon{Code: "SHIRTS", Kind: CouponCategory, Category: "Shirts", Percent: 50},
+			price:      4000,
+			discounts:  []discount{{DiscountPromotion, "fifth", 1500}, {DiscountCoupon, "SHIRTS", 2000}},
+		},
+		{
+			name:      "free item coupon",
+			coupon:    &Coupon{Code: "TIE", Kind: CouponFreeItem, ProductId: "tie"},
+			price:     7000,
+			discounts: []discount{{DiscountCoupon, "TIE", 500}},
+		},
+		{
+			name:      "fixed coupon above the price",
+			coupon:    &Coupon{Code: "HUNDRED", Kind: CouponFixed, Amount: Money{Amount: 10000, Currency: "EUR"}},
+			price:     0,
+			discounts: []discount{{DiscountCoupon, "HUNDRED", 7500}},
+		},
+		{
+			name:   "fixed coupon in another currency",
+			coupon: &Coupon{Code: "DOLLARS", Kind: CouponFixed, Amount: Money{Amount: 1000, Currency: "USD"}},
+			err:    "invalid coupon: DOLLARS is for orders in USD",
+		},
+		{
+			name:   "category coupon without products of the category",
+			coupon: &Coupon{Code: "SHOES", Kind: CouponCategory, Category: "Shoes", Percent: 50},
+			err:    "invalid coupon: SHOES needs products of category Shoes in the order",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var order Order
+			err := priceOrder(&order, pricedLines, tc.promotions, tc.coupon)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("expected %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if order.Subtotal != (Money{Amount: 7500, Currency: "EUR"}) || order.Price != (Money{Amount: tc.price, Currency: "EUR"}) {
+				t.Fatalf("expected 7500 EUR for %d EUR, got %s for %s", tc.price, order.Subtotal, order.Price)
+			}
+			if len(order.Discounts) != len(tc.discounts) {
+				t.Fatalf("expected %d discounts, got %+v", len(tc.discounts), order.Discounts)
+			}
+			for i, d := range tc.discounts {
+				got := order.Discounts[i]
+				if got.Position != i || got.Source != d.source || got.Code != d.code || got.Amount.Amount != d.amount {
+					t.Fatalf("expected discount %d to be %+v, got %+v", i, d, got)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePromotion(t *testing.T) {
+	from := time.Now()
+	until := from.Add(-time.Hour)
+
+	for _, tc := range []struct {
+		name      string
+		promotion Promotion
+		valid     bool
+	}{
+		{"buy 3 get 1", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 1}}, true},
+		{"buy 2 get 1", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 2, Free: 1}}, true},
+		{"free as many as bought", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 2, Free: 2}}, false},
+		{"free more than bought", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 2, Free: 3}}, false},
+		{"nothing free", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 2}}, false},
+		{"buy 1", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 1, Free: 1}}, false},
+		{"tiers", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionVolumeTiers, Tiers: []PromotionTier{{MinQuantity: 1, Percent: 100}}}}, true},
+		{"no tiers", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionVolumeTiers}}, false},
+		{"tier above 100%", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionVolumeTiers, Tiers: []PromotionTier{{MinQuantity: 1, Percent: 101}}}}, false},
+		{"tier without quantity", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionVolumeTiers, Tiers: []PromotionTier{{Percent: 10}}}}, false},
+		{"no name", Promotion{Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 1}}, false},
+		{"unknown kind", Promotion{Name: "p", Rule: PromotionRule{Kind: "half_off"}}, false},
+		{"valid until before valid from", Promotion{Name: "p", Rule: PromotionRule{Kind: PromotionBuyXGetY, Buy: 3, Free: 1}, ValidFrom: &from, ValidUntil: &until}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePromotion(&tc.promotion)
+			if tc.valid && err != nil {
+				t.Fatalf("expected the promotion to be valid, got %s", err)
+			}
+			if !tc.valid && (err == nil || !strings.HasPrefix(err.Error(), "invalid promotion")) {
+				t.Fatalf("expected the promotion to be invalid, got %v", err)
+			}
+		})
+	}
+}
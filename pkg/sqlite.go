@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const defaultSqlitePath = "identifier.sqlite"
+
+// InitSqliteConnection opens the SQLite file named by SQLITE_PATH, falling back
+// to identifier.sqlite.
+func InitSqliteConnection() (*sql.DB, error) {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = defaultSqlitePath
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", path))
+	if err != nil {
+		return nil, fmt.Errorf("database opening failed with error: %s", err.Error())
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("ping sqlite failed with error: %s", err.Error())
+	}
+
+	return db, nil
+}
@@ -1,43 +1,441 @@
 package pkg
 
+import "time"
+
+const (
+	StatusAccepted  = "Accepted"
+	StatusPaid      = "Paid"
+	StatusPacked    = "Packed"
+	StatusShipped   = "Shipped"
+	StatusDelivered = "Delivered"
+	StatusCancelled = "Cancelled"
+	StatusReturned  = "Returned"
+)
+
+// Order is a purchase of products. Name, Address and Phone are the delivery
+// details of the order, taken from the customer when the order is placed.
+// Price is Subtotal, the sum of the products, less the Discounts. CouponCode
+// is the discount code given when the order is placed. Version grows with
+// every change of the stored order.
 type Order struct {
-	ID       string    `json:"id"`
-	Name     string    `json:"name"`
-	Address  string    `json:"address"`
-	Phone    string    `json:"phone"`
-	Products []Product `json:"products"`
-	Price    float64   `json:"price"`
-	Status   string    `json:"status"`
+	ID         string          `json:"id"`
+	CustomerId string          `json:"customer_id,omitempty"`
+	Name       string          `json:"name"`
+	Address    string          `json:"address"`
+	Phone      string          `json:"phone"`
+	Products   []Product       `json:"products"`
+	CouponCode string          `json:"coupon_code,omitempty"`
+	Subtotal   Money           `json:"subtotal"`
+	Discounts  []OrderDiscount `json:"discounts,omitempty"`
+	Price      Money           `json:"price"`
+	Status     string          `json:"status"`
+	Version    int             `json:"version"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Sources of the discounts of an order.
+const (
+	DiscountCoupon    = "coupon"
+	DiscountPromotion = "promotion"
+)
+
+// OrderDiscount is a reduction of the price of an order, recorded with the
+// code that gave it and what it was given for. Code is the coupon code or the
+// id of the promotion. Position is the order the discounts were taken off in.
+type OrderDiscount struct {
+	ID          string `json:"-"`
+	OrderId     string `json:"-"`
+	Position    int    `json:"-"`
+	Source      string `json:"source"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Amount      Money  `json:"amount"`
 }
 
+// Kinds of coupons. Percentage takes Percent off the order, Fixed takes
+// Amount off it, FreeItem gives one item of ProductId for free and Category
+// takes Percent off the products of Category.
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+	CouponFreeItem   = "free_item"
+	CouponCategory   = "category"
+)
+
+// Coupon is a discount code. It can be redeemed between ValidFrom and
+// ValidUntil, MaxUses times in total and MaxUsesPerCustomer times by every
+// customer, where 0 is no limit. Uses counts the orders it was redeemed on.
+type Coupon struct {
+	ID                 string     `json:"id"`
+	Code               string     `json:"code"`
+	Kind               string     `json:"kind"`
+	Percent            int        `json:"percent,omitempty"`
+	Amount             Money      `json:"amount"`
+	ProductId          string     `json:"product_id,omitempty"`
+	Category           string     `json:"category,omitempty"`
+	ValidFrom          *time.Time `json:"valid_from,omitempty"`
+	ValidUntil         *time.Time `json:"valid_until,omitempty"`
+	MaxUses            int        `json:"max_uses"`
+	MaxUsesPerCustomer int        `json:"max_uses_per_customer"`
+	Uses               int        `json:"uses"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// Kinds of promotion rules. BuyXGetY makes the Free cheapest of every Buy
+// matching items free, VolumeTiers takes the Percent of the highest tier
+// reached by the quantity of matching items off them.
+const (
+	PromotionBuyXGetY    = "buy_x_get_y"
+	PromotionVolumeTiers = "volume_tiers"
+)
+
+// PromotionRule is the declarative definition of a promotion. It matches the
+// products of ProductId or of Category, or every product when both are empty.
+type PromotionRule struct {
+	Kind      string          `json:"kind"`
+	ProductId string          `json:"product_id,omitempty"`
+	Category  string          `json:"category,omitempty"`
+	Buy       int             `json:"buy,omitempty"`
+	Free      int             `json:"free,omitempty"`
+	Tiers     []PromotionTier `json:"tiers,omitempty"`
+}
+
+// PromotionTier takes Percent off once MinQuantity matching items are ordered.
+type PromotionTier struct {
+	MinQuantity int `json:"min_quantity"`
+	Percent     int `json:"percent"`
+}
+
+// Promotion is applied to every order placed while it is Active and between
+// ValidFrom and ValidUntil, before the coupon of the order.
+type Promotion struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Rule       PromotionRule `json:"rule"`
+	Active     bool          `json:"active"`
+	ValidFrom  *time.Time    `json:"valid_from,omitempty"`
+	ValidUntil *time.Time    `json:"valid_until,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// CouponRedemption records that a coupon was redeemed on an order. UseNumber
+// numbers the redemptions of a coupon limited per customer from 1 up to the
+// limit, it is 0 for coupons without such a limit.
+type CouponRedemption struct {
+	ID         string
+	CouponId   string
+	OrderId    string
+	CustomerId string
+	UseNumber  int
+	CreatedAt  time.Time
+}
+
+// Product is an item of the catalog. Reserved of its Quantity is held for
+// carts and cannot be ordered by others. DeletedAt is set once the product has
+// been archived. Version grows with every change of the stored product.
 type Product struct {
-	ID       string
-	Name     string
-	Category string
-	Quantity int
-	Price    float64
+	ID        string
+	Name      string
+	Category  string
+	Quantity  int
+	Reserved  int
+	Price     Money
+	DeletedAt *time.Time `json:",omitempty"`
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
+// OrderedProduct is a line of an order. Name, Category and Price are a
+// snapshot of the product taken when the order was placed.
 type OrderedProduct struct {
 	ID              string
 	ProductId       string
 	ProductQuantity int
 	OrderId         string
+	Name            string
+	Category        string
+	Price           Money
+}
+
+// Cart collects products before they are ordered. OrderId is set once the
+// cart is checked out, after which it cannot change. ReservedUntil is set
+// while stock is held for the cart.
+type Cart struct {
+	ID            string     `json:"id"`
+	CustomerId    string     `json:"customer_id,omitempty"`
+	OrderId       string     `json:"order_id,omitempty"`
+	Items         []CartItem `json:"items"`
+	Total         Money      `json:"total"`
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// CartItem is a product in a cart. Name, Category, Price and Available are
+// read from the catalog whenever the cart is viewed. Available counts the
+// stock reserved for the cart and is 0 for archived products.
+type CartItem struct {
+	ProductId string    `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Name      string    `json:"name,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	Price     Money     `json:"price"`
+	Available int       `json:"available"`
+	Reserved  int       `json:"reserved"`
+	InStock   bool      `json:"in_stock"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reservation holds Quantity of a product for a cart until ExpiresAt.
+type Reservation struct {
+	ID        string    `json:"id"`
+	CartId    string    `json:"cart_id"`
+	ProductId string    `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Customer is a buyer of the shop with the details orders are delivered to.
+type Customer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Roles a user of the shop can have.
+const (
+	RoleAdmin    = "admin"
+	RoleStaff    = "staff"
+	RoleCustomer = "customer"
+)
+
+// User is an account that can sign in to the shop. Users with the customer
+// role act for the customer with CustomerId. TokenVersion moves on with every
+// password change, tokens issued at an earlier version are no longer valid.
+type User struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	CustomerId   string    `json:"customer_id,omitempty"`
+	TokenVersion int       `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PasswordReset lets the user set a new password without the old one until
+// ExpiresAt. Only the hash of the token sent to the user is stored.
+type PasswordReset struct {
+	ID        string
+	UserId    string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Scopes an API key can be granted. Keys with the admin scope may do
+// everything an admin may.
+const (
+	ScopeCatalogRead  = "catalog:read"
+	ScopeCatalogWrite = "catalog:write"
+	ScopeOrderRead    = "orders:read"
+	ScopeOrderWrite   = "orders:write"
+	ScopeAdmin        = "admin"
+)
+
+// APIKey lets other systems call the shop unattended within its scopes. Only
+// the hash of the key is stored, Prefix is kept to tell keys apart.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IssuedAPIKey is a newly issued key together with its secret, which is shown
+// only this once.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Token is a signed access token handed out on login.
+type Token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OrderStatusChange is an entry of the status history of an order. From is
+// empty for the status the order was placed with.
+type OrderStatusChange struct {
+	ID        string    `json:"id"`
+	OrderId   string    `json:"order_id"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ProductQuery selects a page of the catalog. Cursor is the NextCursor of the
+// previous page, empty for the first one. MinPrice and MaxPrice are in minor
+// units of PriceCurrency, which selects the products priced in it. Name
+// matches products whose name contains it, SortBy is one of price,
+// name or quantity, or empty for id. InStock selects products with stock that
+// is not reserved. Archived lists archived products instead of the catalog.
+type ProductQuery struct {
+	Limit         int
+	Cursor        string
+	Category      string
+	MinPrice      *int64
+	MaxPrice      *int64
+	PriceCurrency string
+	InStock       bool
+	Name          string
+	SortBy        string
+	Descending    bool
+	Archived      bool
+}
+
+type ProductPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Total      int       `json:"total"`
+}
+
+// OrderQuery selects a page of orders. Cursor is the NextCursor of the
+// previous page, empty for the first one. CustomerId selects the orders of one
+// customer. From and To bound the creation time, From inclusive and To
+// exclusive. SortBy is created_at, or empty for id.
+type OrderQuery struct {
+	Limit      int
+	Cursor     string
+	CustomerId string
+	From       *time.Time
+	To         *time.Time
+	SortBy     string
+	Descending bool
+}
+
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      int     `json:"total"`
 }
 
 type ExampleOrderRequest struct {
-	Name     string `default:"Ivan Ivanov"`
-	Address  string `default:"Sofia Mladost 2"`
-	Phone    string `default:"0888888888"`
-	Products []struct {
+	CustomerId string `json:"customer_id" default:"3f0b8a52-2b1e-4c3a-9d7f-1a2b3c4d5e6f"`
+	Name       string `default:"Ivan Ivanov"`
+	Address    string `default:"Sofia Mladost 2"`
+	Phone      string `default:"0888888888"`
+	Products   []struct {
 		Id       string `default:"bc264186-9c2e-4533-6ba5-705c160303c1"`
 		Quantity int    `default:"2"`
 	}
+	CouponCode string `json:"coupon_code" default:"SUMMER10"`
 }
 
 type ExampleProductRequest struct {
-	Name     string  `default:"Men Red Shirt"`
-	Category string  `default:"Men Shirts"`
-	Quantity int     `default:"1000"`
-	Price    float64 `default:"19.99"`
+	Name     string `default:"Men Red Shirt"`
+	Category string `default:"Men Shirts"`
+	Quantity int    `default:"1000"`
+	Price    struct {
+		Amount   int64  `default:"1999"`
+		Currency string `default:"EUR"`
+	}
+}
+
+type StatusChangeRequest struct {
+	Status string `json:"status" default:"Paid"`
+}
+
+type ExampleCustomerRequest struct {
+	Name    string `json:"name" default:"Ivan Ivanov"`
+	Address string `json:"address" default:"Sofia Mladost 2"`
+	Phone   string `json:"phone" default:"0888888888"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" default:"admin@shop.com"`
+	Password string `json:"password" default:"secret"`
+}
+
+type UserRequest struct {
+	Email      string `json:"email" default:"staff@shop.com"`
+	Password   string `json:"password" default:"secret"`
+	Role       string `json:"role" default:"staff"`
+	CustomerId string `json:"customer_id"`
+}
+
+type RegisterRequest struct {
+	Name     string `json:"name" default:"Ivan Ivanov"`
+	Address  string `json:"address" default:"Sofia Mladost 2"`
+	Phone    string `json:"phone" default:"0888888888"`
+	Email    string `json:"email" default:"ivan@mail.com"`
+	Password string `json:"password" default:"secret-password"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" default:"secret-password"`
+	NewPassword     string `json:"new_password" default:"new-secret-password"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" default:"ivan@mail.com"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password" default:"new-secret-password"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name" default:"warehouse"`
+	Scopes []string `json:"scopes"`
+}
+
+type CartRequest struct {
+	CustomerId string `json:"customer_id"`
+}
+
+type CartItemRequest struct {
+	Quantity int `json:"quantity" default:"1"`
+}
+
+type CheckoutRequest struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Phone      string `json:"phone"`
+	CouponCode string `json:"coupon_code"`
+}
+
+type ExampleCouponRequest struct {
+	Code               string `json:"code" default:"SUMMER10"`
+	Kind               string `json:"kind" default:"percentage"`
+	Percent            int    `json:"percent" default:"10"`
+	ValidFrom          string `json:"valid_from" default:"2021-06-01T00:00:00Z"`
+	ValidUntil         string `json:"valid_until" default:"2021-09-01T00:00:00Z"`
+	MaxUses            int    `json:"max_uses" default:"100"`
+	MaxUsesPerCustomer int    `json:"max_uses_per_customer" default:"1"`
+}
+
+type ExamplePromotionRequest struct {
+	Name string `json:"name" default:"Buy 3 Men Shirts, get the cheapest free"`
+	Rule struct {
+		Kind     string `json:"kind" default:"buy_x_get_y"`
+		Category string `json:"category" default:"Men Shirts"`
+		Buy      int    `json:"buy" default:"3"`
+		Free     int    `json:"free" default:"1"`
+	} `json:"rule"`
+	Active bool `json:"active" default:"true"`
 }